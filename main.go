@@ -1,6 +1,8 @@
 package main
 
 import (
+	"aphoteka_scraper/permanence"
+	"aphoteka_scraper/secrets"
 	"aphoteka_scraper/telegram"
 	"flag"
 	"log"
@@ -10,16 +12,53 @@ import (
 )
 
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+var storeKind = flag.String("store", permanence.StoreFile, "keep data in gob `files` or in a bolt database")
+var configFile = flag.String("config", "", "replace the saved config with a JSON config `file` on start")
+var exportConfig = flag.String("export-config", "", "write the saved config as JSON to `file` and exit")
+var bootstrapFile = flag.String("bootstrap", "", "seed the config from a JSON `file` on the first start")
+var resetFromBootstrap = flag.Bool("reset-from-bootstrap", false, "replace the saved config with the bootstrap one")
 
 func main() {
 	flag.Parse()
 
-	err := telegram.RunServer()
+	if *resetFromBootstrap && *bootstrapFile == "" {
+		log.Fatal("-reset-from-bootstrap needs a -bootstrap file")
+	}
+
+	err := secrets.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = permanence.OpenStore(*storeKind)
+	if err != nil {
+		log.Fatal("could not open store: ", err)
+	}
+
+	if *exportConfig != "" {
+		err = telegram.ExportConfig(*exportConfig)
+		if err != nil {
+			log.Fatal("could not export config: ", err)
+		}
+		permanence.CloseStore()
+		return
+	}
+
+	err = telegram.RunServer(telegram.Options{
+		ConfigFile:         *configFile,
+		BootstrapFile:      *bootstrapFile,
+		ResetFromBootstrap: *resetFromBootstrap,
+	})
 	if err != nil {
 		log.Print(err)
 	}
 	log.Print("Server shut down")
 
+	err = permanence.CloseStore()
+	if err != nil {
+		log.Print(err)
+	}
+
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
 		if err != nil {
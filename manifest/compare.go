@@ -0,0 +1,121 @@
+package manifest
+
+import (
+	"sort"
+	"strings"
+)
+
+// groupKey is the same for products sharing a GTIN, and unique otherwise.
+func groupKey(name string, a Availability) string {
+	if gtin := strings.TrimLeft(a.Gtin, "0"); gtin != "" {
+		return "gtin:" + gtin
+	}
+	return "name:" + name
+}
+
+// cheaper compares by unit price, when both packs are measured in the same
+// unit, and by price otherwise.
+func cheaper(a, b Availability) bool {
+	unitA, okA := a.UnitPrice()
+	unitB, okB := b.UnitPrice()
+	if okA && okB && a.Pack.Unit == b.Pack.Unit {
+		return unitA < unitB
+	}
+	return a.Price.Cents < b.Price.Cents
+}
+
+// sortSources orders products of a group by price, the ones in stock first.
+// Packs of different sizes are compared by unit price.
+func (m Manifest) sortSources(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		a, b := m[names[i]], m[names[j]]
+		if a.Stock.Buyable() != b.Stock.Buyable() {
+			return a.Stock.Buyable()
+		}
+		if cheaper(a, b) || cheaper(b, a) {
+			return cheaper(a, b)
+		}
+		return names[i] < names[j]
+	})
+}
+
+// Groups splits products into groups sharing a GTIN, which are the same
+// product sold by different shops. Products within a group are sorted by
+// price, groups by the name of their first product.
+func (m Manifest) Groups() [][]string {
+	byKey := map[string][]string{}
+	for name, a := range m {
+		key := groupKey(name, a)
+		byKey[key] = append(byKey[key], name)
+	}
+
+	groups := [][]string{}
+	for _, names := range byKey {
+		sort.Strings(names)
+		groups = append(groups, names)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	for _, names := range groups {
+		m.sortSources(names)
+	}
+
+	return groups
+}
+
+// Group returns the products sharing the GTIN of name, including itself,
+// sorted by price.
+func (m Manifest) Group(name string) []string {
+	a, ok := m[name]
+	if !ok {
+		return nil
+	}
+
+	key := groupKey(name, a)
+	names := []string{}
+	for other, b := range m {
+		if groupKey(other, b) == key {
+			names = append(names, other)
+		}
+	}
+	sort.Strings(names)
+	m.sortSources(names)
+
+	return names
+}
+
+// SourceLabels names each product of a group by its shop, unless the shop is
+// not known or sells the product several times, then by the product name.
+func (m Manifest) SourceLabels(names []string) map[string]string {
+	shops := map[string]int{}
+	for _, name := range names {
+		shops[m[name].Shop]++
+	}
+
+	labels := map[string]string{}
+	for _, name := range names {
+		label := m[name].Shop
+		if label == "" || shops[label] > 1 {
+			label = name
+		}
+		labels[name] = label
+	}
+	return labels
+}
+
+// Cheapest returns the product of names, that is in stock for the lowest
+// price.
+func (m Manifest) Cheapest(names []string) (string, bool) {
+	cheapest, found := "", false
+	for _, name := range names {
+		a, ok := m[name]
+		if !ok || !a.Stock.Buyable() {
+			continue
+		}
+		if !found || cheaper(a, m[cheapest]) {
+			cheapest, found = name, true
+		}
+	}
+	return cheapest, found
+}
@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"sort"
+)
+
+// Listing is the content of a category page or saved search, products by
+// url.
+type Listing map[string]ListedProduct
+
+type ListedProduct struct {
+	Name         string
+	Availability Availability
+}
+
+// ListingDiff names urls of products that appeared on, vanished from or
+// changed their price on a listing.
+type ListingDiff struct {
+	Added    []string
+	Removed  []string
+	Repriced []string
+}
+
+func (d ListingDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Repriced) == 0
+}
+
+func DiffListings(prev, next Listing) ListingDiff {
+	var d ListingDiff
+
+	for url, p := range next {
+		old, ok := prev[url]
+		if !ok {
+			d.Added = append(d.Added, url)
+		} else if old.Availability.Price != p.Availability.Price {
+			d.Repriced = append(d.Repriced, url)
+		}
+	}
+	for url := range prev {
+		if _, ok := next[url]; !ok {
+			d.Removed = append(d.Removed, url)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Repriced)
+
+	return d
+}
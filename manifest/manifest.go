@@ -1,65 +1,119 @@
 package manifest
 
 import (
-	"fmt"
 	"maps"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
 type Manifest map[string]Availability
 
 type Availability struct {
-	Price    uint
-	Tag      string
-	Url      string
-	Currency string
+	// Price is what the product costs now, promotions included.
+	Price Money
+	// Found tells whether the page had product data at all.
+	Found bool
+	Stock Stock
+	Url   string
+	// RegularPrice is the crossed-out price during a promotion, zero
+	// otherwise.
+	RegularPrice Money
+	// LoyaltyPrice is the price for loyalty card holders, zero if there is
+	// none.
+	LoyaltyPrice Money
+	// PriceValidUntil is when the promotion ends, if the shop tells.
+	PriceValidUntil time.Time
+	// Pack is read from the name of the product, unless configured.
+	Pack Pack
+	// Stores is the stock in pharmacies, sorted by city and name. Empty,
+	// if the shop does not tell.
+	Stores []Store
+	// Sku and Gtin identify the product, while the url may change.
+	Sku  string
+	Gtin string
+	// Shop selling the product.
+	Shop string
 }
 
+// GenerateMessage renders the manifest as a single plain text message.
 func (m *Manifest) GenerateMessage() string {
-	var builder strings.Builder
+	return strings.TrimSpace(m.Render(FormatPlain, LocaleEnglish, 0)[0])
+}
 
-	keys := []string{}
-	for key := range *m {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
+// Equal compares the fields of availabilities, that notifications are about.
+// Sku and Gtin only identify the product, so filling them in is no change.
+// Stock in pharmacies changes all the time and is only told about by city
+// alerts and /where, so it is not compared either.
+func (a Availability) Equal(b Availability) bool {
+	return a.Price == b.Price &&
+		a.Found == b.Found &&
+		a.Stock == b.Stock &&
+		a.Url == b.Url &&
+		a.RegularPrice == b.RegularPrice &&
+		a.LoyaltyPrice == b.LoyaltyPrice &&
+		a.PriceValidUntil.Equal(b.PriceValidUntil) &&
+		a.Shop == b.Shop &&
+		a.Pack == b.Pack
+}
 
-	for _, name := range keys {
-		availability := (*m)[name]
-		if availability.Tag == "" {
-			fmt.Fprintf(&builder, "- ❌ %v: not found\n%v\n\n", name, availability.Url)
-		} else {
-			parts := strings.Split(availability.Tag, "/")
-			fmt.Fprintf(
-				&builder, "- %s %v: %v @ %.2f %s\n%v\n\n",
-				generate_icon(availability.Tag), name, parts[len(parts)-1],
-				float64(availability.Price)*0.01, availability.Currency, availability.Url,
-			)
-		}
-	}
+// Identical compares availabilities field by field. Availability is not
+// comparable with ==, since it holds the stores.
+func (a Availability) Identical(b Availability) bool {
+	return a.Equal(b) && a.Sku == b.Sku && a.Gtin == b.Gtin && slices.Equal(a.Stores, b.Stores)
+}
 
-	return strings.TrimSpace(builder.String())
+func AreEqual(m1, m2 Manifest) bool {
+	return compareManifests(m1, m2, Availability.Equal)
+}
 
+// AreIdentical tells whether there is anything new to save, even if there is
+// nothing to notify about.
+func AreIdentical(m1, m2 Manifest) bool {
+	return compareManifests(m1, m2, Availability.Identical)
 }
 
-func AreEqual(m1, m2 Manifest) bool {
+func compareManifests(m1, m2 Manifest, eq func(a, b Availability) bool) bool {
 	if m1 == nil && m2 == nil {
 		return true
 	}
 	if m1 == nil || m2 == nil {
 		return false
 	}
-	return maps.Equal(m1, m2)
+	return maps.EqualFunc(m1, m2, eq)
 }
 
-func generate_icon(tag string) string {
-	switch tag {
-	case "https://schema.org/OutOfStock":
-		return "❌"
-	case "https://schema.org/InStock":
-		return "✅"
-	default:
-		return "⚠️"
+// SameProduct tells whether two availabilities belong to the same product,
+// judging by GTIN, or else by SKU. When neither is known on both sides, they
+// cannot be told apart and are considered the same.
+func SameProduct(a, b Availability) bool {
+	// GTIN-8, 12 and 13 are GTIN-14 with leading zeros dropped
+	gtinA, gtinB := strings.TrimLeft(a.Gtin, "0"), strings.TrimLeft(b.Gtin, "0")
+	if gtinA != "" && gtinB != "" {
+		return gtinA == gtinB
 	}
+	if a.Sku != "" && b.Sku != "" {
+		return a.Sku == b.Sku
+	}
+	return true
+}
+
+// Changed returns sorted names of products, that differ between the two
+// manifests or are missing from one of them.
+func Changed(prev, next Manifest) []string {
+	names := []string{}
+	for name, a := range next {
+		if old, ok := prev[name]; !ok || !old.Equal(a) {
+			names = append(names, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
 }
@@ -0,0 +1,147 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrorInvalidPrice = errors.New("not a valid price")
+
+// Money is an exact amount in cents together with its currency code, e.g.
+// "EUR".
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+// Plain decimal amounts, with a point or a comma: "4.35", "4,35", "4", ".5".
+var decimalPattern = regexp.MustCompile(`^([0-9]+([.,][0-9]*)?|[.,][0-9]+)$`)
+
+// ParseMoney reads a decimal amount, as given in product data, without going
+// through floating point. Fractions of a cent are rounded half up. Negative
+// amounts, fractions like "1/3" and exponents like "1e3" are not valid
+// prices.
+func ParseMoney(amount, currency string) (Money, error) {
+	amount = strings.TrimSpace(amount)
+	if !decimalPattern.MatchString(amount) {
+		return Money{}, ErrorInvalidPrice
+	}
+
+	r, ok := new(big.Rat).SetString(strings.ReplaceAll(amount, ",", "."))
+	if !ok {
+		return Money{}, ErrorInvalidPrice
+	}
+
+	// round half up: floor(amount * 100 + 1/2)
+	r.Mul(r, big.NewRat(100, 1))
+	r.Add(r, big.NewRat(1, 2))
+	cents := new(big.Int).Quo(r.Num(), r.Denom())
+	if !cents.IsInt64() {
+		return Money{}, ErrorInvalidPrice
+	}
+
+	return Money{Cents: cents.Int64(), Currency: strings.ToUpper(strings.TrimSpace(currency))}, nil
+}
+
+// FromCents makes Money of an amount kept in cents, like the target prices.
+func FromCents(cents int64, currency string) Money {
+	return Money{Cents: cents, Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Cents == 0
+}
+
+// Float is the amount in whole units, for computations that need not be
+// exact, e.g. percentages and unit prices.
+func (m Money) Float() float64 {
+	return float64(m.Cents) / 100
+}
+
+// String renders the amount with the currency code, e.g. "4.35 EUR".
+func (m Money) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", decimalString(m.Cents, ".", ""), m.Currency))
+}
+
+// Format renders the amount the way it is written in locale.
+func (m Money) Format(l Locale) string {
+	return formatAmount(l, decimalString(m.Cents, l.decimalSeparator(), l.groupSeparator()), m.Currency)
+}
+
+// decimalString writes cents as a decimal number with two digits after the
+// separator, grouping thousands.
+func decimalString(cents int64, decimal, group string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	whole := strconv.FormatInt(cents/100, 10)
+	if group != "" {
+		for i := len(whole) - 3; i > 0; i -= 3 {
+			whole = whole[:i] + group + whole[i:]
+		}
+	}
+
+	return fmt.Sprintf("%s%s%s%02d", sign, whole, decimal, cents%100)
+}
+
+// Locale decides how amounts are written. Values match the names users type
+// in commands.
+type Locale string
+
+const (
+	LocaleEnglish Locale = "en"
+	LocaleLatvian Locale = "lv"
+)
+
+func ParseLocale(s string) (Locale, bool) {
+	switch l := Locale(strings.ToLower(s)); l {
+	case LocaleEnglish, LocaleLatvian:
+		return l, true
+	default:
+		return "", false
+	}
+}
+
+var currencySymbols = map[string]string{
+	"EUR": "€",
+	"USD": "$",
+	"GBP": "£",
+}
+
+func (l Locale) decimalSeparator() string {
+	if l == LocaleLatvian {
+		return ","
+	}
+	return "."
+}
+
+func (l Locale) groupSeparator() string {
+	if l == LocaleLatvian {
+		return "\u00a0"
+	}
+	return ","
+}
+
+// formatAmount puts the currency where locale expects it: "€4.35" in
+// English, "4,35 €" in Latvian. Currencies without a known symbol are
+// written as their code after the amount.
+func formatAmount(l Locale, amount, currency string) string {
+	symbol, ok := currencySymbols[currency]
+	switch {
+	case currency == "":
+		return amount
+	case ok && l != LocaleLatvian:
+		return symbol + amount
+	case ok:
+		return amount + "\u00a0" + symbol
+	default:
+		return amount + " " + currency
+	}
+}
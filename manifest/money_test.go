@@ -0,0 +1,100 @@
+package manifest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     Money
+	}{
+		{"4.35", "EUR", Money{Cents: 435, Currency: "EUR"}},
+		{"4,35", "eur", Money{Cents: 435, Currency: "EUR"}},
+		{" 12 ", " EUR ", Money{Cents: 1200, Currency: "EUR"}},
+		{"0", "EUR", Money{Cents: 0, Currency: "EUR"}},
+		{"5.", "", Money{Cents: 500}},
+		{".5", "", Money{Cents: 50}},
+		{"1234.56", "EUR", Money{Cents: 123456, Currency: "EUR"}},
+		// fractions of a cent are rounded half up
+		{"2.344", "EUR", Money{Cents: 234, Currency: "EUR"}},
+		{"2.345", "EUR", Money{Cents: 235, Currency: "EUR"}},
+		{"2.3449999", "EUR", Money{Cents: 234, Currency: "EUR"}},
+		{"0.005", "EUR", Money{Cents: 1, Currency: "EUR"}},
+		{"0.004", "EUR", Money{Cents: 0, Currency: "EUR"}},
+		{"9.995", "EUR", Money{Cents: 1000, Currency: "EUR"}},
+		// floating point would make this 1.00
+		{"1.005", "EUR", Money{Cents: 101, Currency: "EUR"}},
+	}
+
+	for _, test := range tests {
+		got, err := ParseMoney(test.amount, test.currency)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", test.amount, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseMoney(%q, %q) = %+v, want %+v", test.amount, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, amount := range []string{
+		"", " ", "-1", "-0.01", "+1", "1/3", "1e3", "1E-2", "0x10",
+		"1.2.3", "1,2,3", "4.35 EUR", "€4.35", "1 234,56", ".", ",", "abc",
+		"99999999999999999999",
+	} {
+		_, err := ParseMoney(amount, "EUR")
+		if !errors.Is(err, ErrorInvalidPrice) {
+			t.Errorf("ParseMoney(%q): got %v, want %v", amount, err, ErrorInvalidPrice)
+		}
+	}
+}
+
+// Latvian amounts are grouped and followed by the symbol with non-breaking
+// spaces, so that a price is never split across lines.
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		money   Money
+		english string
+		latvian string
+	}{
+		{Money{Cents: 435, Currency: "EUR"}, "€4.35", "4,35\u00a0€"},
+		{Money{Cents: 5, Currency: "EUR"}, "€0.05", "0,05\u00a0€"},
+		{Money{Cents: 0, Currency: "EUR"}, "€0.00", "0,00\u00a0€"},
+		{Money{Cents: 123456, Currency: "EUR"}, "€1,234.56", "1\u00a0234,56\u00a0€"},
+		{Money{Cents: 123456789, Currency: "EUR"}, "€1,234,567.89", "1\u00a0234\u00a0567,89\u00a0€"},
+		{Money{Cents: 100000, Currency: "USD"}, "$1,000.00", "1\u00a0000,00\u00a0$"},
+		{Money{Cents: 199, Currency: "PLN"}, "1.99 PLN", "1,99 PLN"},
+		{Money{Cents: 199}, "1.99", "1,99"},
+	}
+
+	for _, test := range tests {
+		if got := test.money.Format(LocaleEnglish); got != test.english {
+			t.Errorf("%+v in English: got %q, want %q", test.money, got, test.english)
+		}
+		if got := test.money.Format(LocaleLatvian); got != test.latvian {
+			t.Errorf("%+v in Latvian: got %q, want %q", test.money, got, test.latvian)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{Cents: 435, Currency: "EUR"}, "4.35 EUR"},
+		{Money{Cents: 123456, Currency: "EUR"}, "1234.56 EUR"},
+		{Money{Cents: 7}, "0.07"},
+	}
+
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.money, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package manifest
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Format is the markup a manifest is rendered in. Values match the names
+// users type in commands.
+type Format string
+
+const (
+	FormatPlain    Format = "plain"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// MessageLimit is the maximum length of a single Telegram message.
+const MessageLimit = 4096
+
+func ParseFormat(s string) (Format, bool) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatPlain, FormatMarkdown, FormatHTML:
+		return f, true
+	default:
+		return "", false
+	}
+}
+
+// Render renders the manifest and splits it into messages no longer than
+// limit. Messages are only split between products, unless a single product
+// does not fit into a message on its own. Limit 0 means no limit.
+func (m *Manifest) Render(format Format, locale Locale, limit int) []string {
+	entries := []string{}
+	for _, names := range m.Groups() {
+		entry := renderNames(format, locale, names, *m)
+		// markup cannot be split across messages, so an entry with a line too
+		// long for a message is sent as escaped plain text instead
+		if limit > 0 && format != FormatPlain && longestLine(entry) > limit {
+			entry = Escape(format, renderNames(FormatPlain, locale, names, *m))
+		}
+		entries = append(entries, entry)
+	}
+
+	return Chunk(entries, "\n\n", limit)
+}
+
+func renderNames(format Format, locale Locale, names []string, m Manifest) string {
+	if len(names) == 1 {
+		return renderEntry(format, locale, names[0], m[names[0]])
+	}
+	return renderGroup(format, locale, names, m)
+}
+
+func longestLine(s string) int {
+	longest := 0
+	for _, line := range strings.Split(s, "\n") {
+		longest = max(longest, messageLength(line))
+	}
+	return longest
+}
+
+// renderGroup renders the same product sold by several shops, one line per
+// shop, cheapest first. Products from the same shop are labelled by name.
+func renderGroup(format Format, locale Locale, names []string, m Manifest) string {
+	title := Escape(format, names[0])
+	switch format {
+	case FormatHTML:
+		title = "<b>" + title + "</b>"
+	case FormatMarkdown:
+		title = "*" + title + "*"
+	}
+
+	labels := m.SourceLabels(names)
+	lines := []string{"🛒 " + title}
+	for _, name := range names {
+		line := renderEntry(format, locale, labels[name], m[name])
+		if format != FormatHTML && format != FormatMarkdown {
+			line = "  " + strings.ReplaceAll(line, "\n", "\n  ")
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func renderEntry(format Format, locale Locale, name string, availability Availability) string {
+	switch format {
+	case FormatHTML:
+		link := fmt.Sprintf(`<a href="%s"><b>%s</b></a>`,
+			html.EscapeString(availability.Url), Escape(format, name))
+		if !availability.Found {
+			return fmt.Sprintf("❌ %s: not found", link)
+		}
+		return fmt.Sprintf("%s %s: %s @ %s",
+			availability.Stock.Icon(), link,
+			Escape(format, availability.Stock.Wording()),
+			Escape(format, formatPrice(availability, locale)))
+
+	case FormatMarkdown:
+		link := fmt.Sprintf("*[%s](%s)*",
+			Escape(format, name), escapeMarkdownUrl(availability.Url))
+		if !availability.Found {
+			return fmt.Sprintf("❌ %s: not found", link)
+		}
+		return fmt.Sprintf("%s %s: %s @ %s",
+			availability.Stock.Icon(), link,
+			Escape(format, availability.Stock.Wording()),
+			Escape(format, formatPrice(availability, locale)))
+
+	default:
+		if !availability.Found {
+			return fmt.Sprintf("- ❌ %v: not found\n%v", name, availability.Url)
+		}
+		return fmt.Sprintf("- %s %v: %v @ %s\n%v",
+			availability.Stock.Icon(), name, availability.Stock.Wording(),
+			formatPrice(availability, locale), availability.Url)
+	}
+}
+
+func formatPrice(availability Availability, locale Locale) string {
+	price := availability.Price.Format(locale)
+	notes := []string{}
+	if note := availability.UnitPriceNote(locale); note != "" {
+		notes = append(notes, note)
+	}
+	if note := availability.SaleNote(locale); note != "" {
+		notes = append(notes, note)
+	}
+	if len(notes) > 0 {
+		price += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return price
+}
+
+// Escape makes s safe to put into a message rendered in format.
+func Escape(format Format, s string) string {
+	switch format {
+	case FormatHTML:
+		return html.EscapeString(s)
+	case FormatMarkdown:
+		return escapeMarkdown(s)
+	default:
+		return s
+	}
+}
+
+// escapeMarkdown escapes text for Telegram MarkdownV2.
+func escapeMarkdown(s string) string {
+	var builder strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("\\_*[]()~`>#+-=|{}.!", r) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// escapeMarkdownUrl escapes the url part of a MarkdownV2 inline link, where
+// only ')' and '\' are special.
+func escapeMarkdownUrl(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, ")", `\)`)
+}
+
+// messageLength counts characters the way Telegram does, in UTF-16 units.
+func messageLength(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// Chunk joins parts with sep into as few strings no longer than limit, as
+// possible. Parts longer than limit are cut.
+func Chunk(parts []string, sep string, limit int) []string {
+	if limit <= 0 {
+		return []string{strings.Join(parts, sep)}
+	}
+
+	result := []string{}
+	current := ""
+	for _, part := range parts {
+		for messageLength(part) > limit {
+			if current != "" {
+				result = append(result, current)
+				current = ""
+			}
+			head, tail := cut(part, limit)
+			result = append(result, head)
+			part = tail
+		}
+
+		if current == "" {
+			current = part
+		} else if messageLength(current)+messageLength(sep)+messageLength(part) <= limit {
+			current += sep + part
+		} else {
+			result = append(result, current)
+			current = part
+		}
+	}
+	if current != "" || len(result) == 0 {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+// cut splits s after at most limit UTF-16 units. It cuts at the last line
+// break, if there is one, since markup never spans lines. Otherwise it does
+// not cut through an HTML entity or a MarkdownV2 escape.
+func cut(s string, limit int) (string, string) {
+	end := len(s)
+	n := 0
+	for i, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+		if n > limit {
+			end = i
+			break
+		}
+	}
+	if end == len(s) {
+		return s, ""
+	}
+
+	if i := strings.LastIndex(s[:end], "\n"); i > 0 {
+		return s[:i], s[i+1:]
+	}
+
+	safe := end
+	if i := strings.LastIndex(s[:end], "&"); i >= 0 && isEntityName(s[i+1:end]) {
+		safe = i
+	}
+	// an odd number of backslashes escapes the character after the cut
+	backslashes := len(s[:safe]) - len(strings.TrimRight(s[:safe], `\`))
+	if backslashes%2 == 1 {
+		safe--
+	}
+	if safe > 0 {
+		end = safe
+	}
+
+	return s[:end], s[end:]
+}
+
+// isEntityName reports whether s could be the start of an HTML entity, that
+// follows '&'.
+func isEntityName(s string) bool {
+	if len(s) > 8 {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '#' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package manifest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// OnSale tells whether the price is a promotion, with the regular price
+// crossed out.
+func (a Availability) OnSale() bool {
+	return a.RegularPrice.Cents > a.Price.Cents
+}
+
+// Discount is how much cheaper the promotion is, in percent.
+func (a Availability) Discount() int {
+	if !a.OnSale() {
+		return 0
+	}
+	return int(math.Round(float64(a.RegularPrice.Cents-a.Price.Cents) * 100 / float64(a.RegularPrice.Cents)))
+}
+
+// SaleNote describes the promotion and the loyalty card price, e.g. "on sale
+// until 31.10, -25%". Empty for a product at its regular price.
+func (a Availability) SaleNote(l Locale) string {
+	notes := []string{}
+	if a.OnSale() {
+		if a.PriceValidUntil.IsZero() {
+			notes = append(notes, fmt.Sprintf("on sale, -%d%%", a.Discount()))
+		} else {
+			notes = append(notes, fmt.Sprintf("on sale until %s, -%d%%",
+				a.PriceValidUntil.Format("02.01"), a.Discount()))
+		}
+	}
+	if !a.LoyaltyPrice.IsZero() && a.LoyaltyPrice.Cents < a.Price.Cents {
+		notes = append(notes, fmt.Sprintf("%s with loyalty card", a.LoyaltyPrice.Format(l)))
+	}
+	return strings.Join(notes, ", ")
+}
+
+// PriceChange describes how the price of a product changed between two
+// checks, telling a promotion apart from a permanent change. Empty if the
+// price did not change.
+func PriceChange(prev, next Availability, l Locale) string {
+	if !prev.Found || !next.Found {
+		return ""
+	}
+
+	switch {
+	case next.OnSale() && (!prev.OnSale() || prev.Price != next.Price):
+		return fmt.Sprintf("%s, %s instead of %s", next.SaleNote(l),
+			next.Price.Format(l), next.RegularPrice.Format(l))
+	case prev.OnSale() && !next.OnSale():
+		return fmt.Sprintf("sale is over, %s again", next.Price.Format(l))
+	case !next.OnSale() && prev.Price != next.Price:
+		return fmt.Sprintf("price changed permanently from %s to %s",
+			prev.Price.Format(l), next.Price.Format(l))
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,135 @@
+package manifest
+
+import (
+	"strings"
+)
+
+// Stock is the availability of a product, as schema.org defines it.
+type Stock int
+
+const (
+	StockUnknown Stock = iota
+	StockInStock
+	StockOutOfStock
+	StockPreOrder
+	StockBackOrder
+	StockLimitedAvailability
+	StockDiscontinued
+	StockSoldOut
+	StockInStoreOnly
+	StockOnlineOnly
+)
+
+var stockNames = map[Stock]string{
+	StockUnknown:             "Unknown",
+	StockInStock:             "InStock",
+	StockOutOfStock:          "OutOfStock",
+	StockPreOrder:            "PreOrder",
+	StockBackOrder:           "BackOrder",
+	StockLimitedAvailability: "LimitedAvailability",
+	StockDiscontinued:        "Discontinued",
+	StockSoldOut:             "SoldOut",
+	StockInStoreOnly:         "InStoreOnly",
+	StockOnlineOnly:          "OnlineOnly",
+}
+
+var stockWording = map[Stock]string{
+	StockUnknown:             "availability unknown",
+	StockInStock:             "in stock",
+	StockOutOfStock:          "out of stock",
+	StockPreOrder:            "available for pre-order",
+	StockBackOrder:           "on back order",
+	StockLimitedAvailability: "limited availability",
+	StockDiscontinued:        "discontinued",
+	StockSoldOut:             "sold out",
+	StockInStoreOnly:         "in pharmacies only",
+	StockOnlineOnly:          "online only",
+}
+
+var stockIcons = map[Stock]string{
+	StockUnknown:             "⚠️",
+	StockInStock:             "✅",
+	StockOutOfStock:          "❌",
+	StockPreOrder:            "🕒",
+	StockBackOrder:           "⏳",
+	StockLimitedAvailability: "🟡",
+	StockDiscontinued:        "🚫",
+	StockSoldOut:             "⛔",
+	StockInStoreOnly:         "🏪",
+	StockOnlineOnly:          "🌐",
+}
+
+// ParseStock reads a schema.org availability, given as a full url with
+// either scheme, as "schema:InStock" or as a bare name. Case does not
+// matter. Anything else is StockUnknown.
+func ParseStock(s string) Stock {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"https://schema.org/", "http://schema.org/", "schema:"} {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			s = s[len(prefix):]
+			break
+		}
+	}
+
+	for stock, name := range stockNames {
+		if strings.EqualFold(s, name) {
+			return stock
+		}
+	}
+	return StockUnknown
+}
+
+// LookupStock is ParseStock, that also tells whether s names a state at all.
+// "Unknown" is a state too, so that alerts can be set on it.
+func LookupStock(s string) (Stock, bool) {
+	stock := ParseStock(s)
+	if stock != StockUnknown {
+		return stock, true
+	}
+	return stock, strings.EqualFold(strings.TrimSpace(s), stockNames[StockUnknown])
+}
+
+// String is the schema.org name of the state, e.g. "InStock".
+func (s Stock) String() string {
+	if name, ok := stockNames[s]; ok {
+		return name
+	}
+	return stockNames[StockUnknown]
+}
+
+// Wording describes the state to people, e.g. "in stock".
+func (s Stock) Wording() string {
+	if wording, ok := stockWording[s]; ok {
+		return wording
+	}
+	return stockWording[StockUnknown]
+}
+
+func (s Stock) Icon() string {
+	if icon, ok := stockIcons[s]; ok {
+		return icon
+	}
+	return stockIcons[StockUnknown]
+}
+
+// Buyable tells whether the product can be bought right now, online or in a
+// pharmacy.
+func (s Stock) Buyable() bool {
+	switch s {
+	case StockInStock, StockLimitedAvailability, StockInStoreOnly, StockOnlineOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stocks are saved by name, so that files stay readable and do not depend on
+// the order of the constants.
+func (s Stock) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Stock) UnmarshalText(text []byte) error {
+	*s = ParseStock(string(text))
+	return nil
+}
@@ -0,0 +1,41 @@
+package manifest
+
+import (
+	"slices"
+	"strings"
+)
+
+// Store is a pharmacy and its stock of a product, for shops that tell stock
+// per pickup location.
+type Store struct {
+	Name    string
+	Address string
+	City    string
+	Stock   Stock
+}
+
+// SortStores orders stores by city, name and address, so that equal stock
+// compares equal. Pharmacies of a chain share their name, so the address
+// tells them apart.
+func SortStores(stores []Store) {
+	slices.SortStableFunc(stores, func(a, b Store) int {
+		if c := strings.Compare(a.City, b.City); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Address, b.Address)
+	})
+}
+
+// StoresIn returns stores in the given city, ignoring case.
+func (a Availability) StoresIn(city string) []Store {
+	stores := []Store{}
+	for _, store := range a.Stores {
+		if strings.EqualFold(strings.TrimSpace(store.City), strings.TrimSpace(city)) {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Units a pack size is measured in. Litres and kilograms are converted to
+// millilitres and grams, so that products of different sizes compare.
+const (
+	UnitPiece      = "pcs"
+	UnitMillilitre = "ml"
+	UnitGram       = "g"
+)
+
+// Pack is how much of the product a single package contains.
+type Pack struct {
+	Size float64
+	Unit string
+}
+
+func (p Pack) Known() bool {
+	return p.Size > 0 && p.Unit != ""
+}
+
+func (p Pack) String() string {
+	return fmt.Sprintf("%g %s", p.Size, p.Unit)
+}
+
+// ParsePack reads a pack size typed by a user, e.g. 100 and "ml". Larger
+// units are converted.
+func ParsePack(size float64, unit string) (Pack, bool) {
+	switch strings.ToLower(strings.TrimSuffix(unit, ".")) {
+	case "pcs", "pc", "tab", "tablets", "caps", "capsules", "gab":
+		return Pack{Size: size, Unit: UnitPiece}, size > 0
+	case "ml":
+		return Pack{Size: size, Unit: UnitMillilitre}, size > 0
+	case "l":
+		return Pack{Size: size * 1000, Unit: UnitMillilitre}, size > 0
+	case "g":
+		return Pack{Size: size, Unit: UnitGram}, size > 0
+	case "kg":
+		return Pack{Size: size * 1000, Unit: UnitGram}, size > 0
+	default:
+		return Pack{}, false
+	}
+}
+
+// UnitPrice is the price of a single piece, millilitre or gram, in the
+// currency of the product.
+func (a Availability) UnitPrice() (float64, bool) {
+	if !a.Pack.Known() || a.Price.IsZero() {
+		return 0, false
+	}
+	return a.Price.Float() / a.Pack.Size, true
+}
+
+// FormatUnitPrice renders a price per unit with enough decimals to tell
+// cheap units apart, e.g. "€0.042/pcs".
+func FormatUnitPrice(price float64, currency, unit string, l Locale) string {
+	decimals := 2
+	if price < 1 {
+		decimals = 3
+	}
+	if price < 0.1 {
+		decimals = 4
+	}
+	amount := strings.Replace(strconv.FormatFloat(price, 'f', decimals, 64), ".", l.decimalSeparator(), 1)
+	return formatAmount(l, amount, currency) + "/" + unit
+}
+
+// UnitPriceNote is the unit price of a product ready to show, or an empty
+// string if the pack size is not known.
+func (a Availability) UnitPriceNote(l Locale) string {
+	price, ok := a.UnitPrice()
+	if !ok {
+		return ""
+	}
+	return FormatUnitPrice(price, a.Price.Currency, a.Pack.Unit, l)
+}
@@ -0,0 +1,187 @@
+package permanence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// How many previous versions of every file are kept as backups, named
+// <file>.1 (the newest) to <file>.<backupCount>.
+const backupCount = 3
+
+// Recovery tells that a file could not be decoded and a backup was loaded
+// instead.
+type Recovery struct {
+	File   string
+	Backup string
+	Err    error
+}
+
+var recoveries []Recovery
+var recoveriesMutex sync.Mutex
+
+// Files, that could not be decoded when they were last read. They are not
+// rotated into the backups, which would push out a good backup.
+var undecodable = map[string]bool{}
+var undecodableMutex sync.Mutex
+
+func setUndecodable(filename string, bad bool) {
+	undecodableMutex.Lock()
+	defer undecodableMutex.Unlock()
+
+	if bad {
+		undecodable[filename] = true
+	} else {
+		delete(undecodable, filename)
+	}
+}
+
+func isUndecodable(filename string) bool {
+	undecodableMutex.Lock()
+	defer undecodableMutex.Unlock()
+
+	return undecodable[filename]
+}
+
+// TakeRecoveries returns the recoveries since the last call, so that they
+// are reported once.
+func TakeRecoveries() []Recovery {
+	recoveriesMutex.Lock()
+	defer recoveriesMutex.Unlock()
+
+	r := recoveries
+	recoveries = nil
+	return r
+}
+
+func backupName(filename string, i int) string {
+	return fmt.Sprintf("%s.%d", filename, i)
+}
+
+// writeTemp writes data into a new temporary file next to filename and
+// syncs it to disk. Returns the name of the temporary file.
+func writeTemp(filename string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// WriteFile replaces a file so that a crash or a full disk never leaves it
+// half written: data goes into a temporary file next to it, which is synced
+// and renamed over the old one. The old content becomes the newest backup.
+func WriteFile(filename string, data []byte) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := writeTemp(filename, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	err = rotateBackups(filename)
+	if err != nil {
+		return errors.Join(errors.New("cannot back up "+filename), err)
+	}
+
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		return err
+	}
+	setUndecodable(filename, false)
+
+	// make the rename itself durable, not supported everywhere
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// rotateBackups shifts the backups of a file by one and copies the current
+// file into the newest backup. The current file stays in place, so that
+// there is always a complete file to load. A current file, that could not be
+// decoded, is not backed up: it is about to be replaced anyway.
+func rotateBackups(filename string) error {
+	if isUndecodable(filename) {
+		return nil
+	}
+
+	current, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for i := backupCount - 1; i >= 1; i-- {
+		err = os.Rename(backupName(filename, i), backupName(filename, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	tmp, err := writeTemp(filename, current)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, backupName(filename, 1))
+}
+
+// ReadFile reads and decodes a file. When it cannot be read or decoded, the
+// backups are tried from the newest one, and the first good one is used and
+// recorded as a recovery. A file written by a newer version of the bot is
+// not broken, so its backups are not tried. Decode may be called several
+// times and has to start from scratch every time. If the file does not
+// exist, the error satisfies os.IsNotExist.
+func ReadFile(filename string, decode func(data []byte) error) error {
+	data, err := os.ReadFile(filename)
+	if err != nil && os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		err = decode(data)
+		if err == nil || errors.Is(err, ErrorNewerSchema) {
+			setUndecodable(filename, false)
+			return err
+		}
+	}
+	setUndecodable(filename, true)
+
+	for i := 1; i <= backupCount; i++ {
+		backup := backupName(filename, i)
+		data, backupErr := os.ReadFile(backup)
+		if backupErr != nil || decode(data) != nil {
+			continue
+		}
+
+		recoveriesMutex.Lock()
+		recoveries = append(recoveries, Recovery{File: filename, Backup: backup, Err: err})
+		recoveriesMutex.Unlock()
+
+		return nil
+	}
+
+	return err
+}
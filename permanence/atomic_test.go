@@ -0,0 +1,73 @@
+package permanence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func decodeText(want string) func(data []byte) error {
+	return func(data []byte) error {
+		if string(data) != want {
+			return errors.New("unexpected content " + string(data))
+		}
+		return nil
+	}
+}
+
+func TestReadFileRecoversFromBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "value.gob")
+	for _, content := range []string{"good", "broken"} {
+		if err := WriteFile(filename, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	TakeRecoveries()
+
+	err := ReadFile(filename, decodeText("good"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	recovered := TakeRecoveries()
+	if len(recovered) != 1 || recovered[0].Backup != backupName(filename, 1) {
+		t.Errorf("got recoveries %+v", recovered)
+	}
+
+	// the broken file is replaced without pushing the good backup out
+	if err := WriteFile(filename, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(backupName(filename, 1))
+	if err != nil || string(backup) != "good" {
+		t.Errorf("got backup %q, %v, want \"good\"", backup, err)
+	}
+}
+
+func TestReadFileNewerSchema(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "value.gob")
+	for _, content := range []string{"old", "newer"} {
+		if err := WriteFile(filename, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	TakeRecoveries()
+
+	tried := []string{}
+	err := ReadFile(filename, func(data []byte) error {
+		tried = append(tried, string(data))
+		if string(data) == "newer" {
+			return ErrorNewerSchema
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrorNewerSchema) {
+		t.Errorf("got %v, want %v", err, ErrorNewerSchema)
+	}
+	if len(tried) != 1 {
+		t.Errorf("backups were tried: %q", tried)
+	}
+	if recovered := TakeRecoveries(); len(recovered) != 0 {
+		t.Errorf("got recoveries %+v", recovered)
+	}
+}
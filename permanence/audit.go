@@ -0,0 +1,101 @@
+package permanence
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// The audit log is a JSON lines log, like the history.
+const auditKey = "audit.jsonl"
+
+// AuditChange is a single setting changed. An empty value means the setting
+// was not there.
+type AuditChange struct {
+	Key    string
+	Before string
+	After  string
+}
+
+// AuditEntry tells who changed the config, when and how.
+type AuditEntry struct {
+	Id      int
+	Time    time.Time
+	Actor   string
+	Action  string
+	Changes []AuditChange
+}
+
+var auditMutex sync.Mutex
+
+// The id of the last entry in the audit log of auditStore. The log is only
+// read once per store, later entries are numbered from memory.
+var lastAuditId int
+var auditStore Store
+
+// AppendAudit numbers the entry and adds it to the audit log. Returns the
+// numbered entry.
+func AppendAudit(entry AuditEntry) (AuditEntry, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	st, err := getStore()
+	if err != nil {
+		return entry, err
+	}
+
+	if st != auditStore {
+		last := 0
+		err = st.Records(auditKey, func(record []byte) error {
+			var entry AuditEntry
+			err := json.Unmarshal(record, &entry)
+			if err != nil {
+				return err
+			}
+			last = entry.Id
+			return nil
+		})
+		if err != nil {
+			return entry, err
+		}
+		lastAuditId = last
+		auditStore = st
+	}
+	entry.Id = lastAuditId + 1
+
+	record, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	err = st.Append(auditKey, record)
+	if err != nil {
+		return entry, err
+	}
+	lastAuditId = entry.Id
+	return entry, nil
+}
+
+// LoadAudit returns the whole audit log, oldest first.
+func LoadAudit() ([]AuditEntry, error) {
+	st, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	err = st.Records(auditKey, func(record []byte) error {
+		var entry AuditEntry
+		err := json.Unmarshal(record, &entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,56 @@
+package permanence
+
+import (
+	"testing"
+)
+
+func useStore(t *testing.T, s Store) {
+	t.Helper()
+	storeMutex.Lock()
+	previous := store
+	store = s
+	storeMutex.Unlock()
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		store = previous
+		storeMutex.Unlock()
+	})
+}
+
+func TestAppendAuditNumbersEntries(t *testing.T) {
+	dir := t.TempDir()
+	useStore(t, NewFileStore(dir))
+
+	for want := 1; want <= 2; want++ {
+		entry, err := AppendAudit(AuditEntry{Actor: "@root", Action: "/add_user @pharmacist"})
+		if err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+		if entry.Id != want {
+			t.Errorf("got id %d, want %d", entry.Id, want)
+		}
+	}
+
+	// a store opened anew continues after the last entry in the log
+	useStore(t, NewFileStore(dir))
+	entry, err := AppendAudit(AuditEntry{Actor: "@root", Action: "/remove_user @pharmacist"})
+	if err != nil {
+		t.Fatalf("AppendAudit: %v", err)
+	}
+	if entry.Id != 3 {
+		t.Errorf("got id %d after reopening, want 3", entry.Id)
+	}
+
+	entries, err := LoadAudit()
+	if err != nil {
+		t.Fatalf("LoadAudit: %v", err)
+	}
+	for i, entry := range entries {
+		if entry.Id != i+1 {
+			t.Errorf("entry %d has id %d", i, entry.Id)
+		}
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(entries))
+	}
+}
@@ -0,0 +1,153 @@
+package permanence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const boltFilename = "store.db"
+
+var valuesBucket = []byte("values")
+
+// BoltStore keeps everything in a single bbolt database. Values live in one
+// bucket, every log has a bucket of its own, keyed by sequence number.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the database in dir. A new database starts with the
+// data of the file store in the same directory, so that switching to it keeps
+// the config and history. The files are not updated afterwards.
+func OpenBoltStore(dir string) (*BoltStore, error) {
+	filename := path.Join(dir, boltFilename)
+	_, err := os.Stat(filename)
+	fresh := os.IsNotExist(err)
+
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltStore{db: db}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(valuesBucket)
+		return err
+	})
+	if err == nil && fresh {
+		err = s.importFiles(NewFileStore(dir))
+	}
+	if err != nil {
+		db.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// importFiles copies every value (.gob) and log (.jsonl) of a file store.
+func (s *BoltStore) importFiles(files *FileStore) error {
+	entries, err := os.ReadDir(files.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		key := entry.Name()
+		switch filepath.Ext(key) {
+		case ".gob":
+			err = files.Load(key, func(data []byte) error {
+				return s.Save(key, data)
+			})
+		case ".jsonl":
+			records := [][]byte{}
+			err = files.Records(key, func(record []byte) error {
+				records = append(records, bytes.Clone(record))
+				return nil
+			})
+			if err == nil {
+				err = s.Append(key, records...)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func logBucket(key string) []byte {
+	return []byte("log:" + key)
+}
+
+func (s *BoltStore) Load(key string, decode func(data []byte) error) error {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// values are only valid during the transaction
+		data = bytes.Clone(tx.Bucket(valuesBucket).Get([]byte(key)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return ErrorNotFound
+	}
+
+	return decode(data)
+}
+
+func (s *BoltStore) Save(key string, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(valuesBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStore) Append(key string, records ...[]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(logBucket(key))
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if bytes.ContainsRune(record, '\n') {
+				return ErrorNewlineInRecord
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(binary.BigEndian.AppendUint64(nil, seq), record)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) Records(key string, each func(record []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logBucket(key))
+		if bucket == nil {
+			return nil
+		}
+		// sequence numbers are big endian, so the cursor walks oldest first
+		return bucket.ForEach(func(_, record []byte) error {
+			return each(record)
+		})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,134 @@
+package permanence
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"path"
+)
+
+// Records longer than this cannot be read back from a file.
+const maxRecordSize = 1 << 20
+
+// FileStore keeps every key in a file of the same name in a directory.
+// Values are written atomically with backups, logs are JSON lines files.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) Load(key string, decode func(data []byte) error) error {
+	err := ReadFile(path.Join(s.Dir, key), decode)
+	if os.IsNotExist(err) {
+		return ErrorNotFound
+	}
+	return err
+}
+
+func (s *FileStore) Save(key string, data []byte) error {
+	return WriteFile(path.Join(s.Dir, key), data)
+}
+
+func (s *FileStore) Append(key string, records ...[]byte) error {
+	for _, record := range records {
+		if bytes.ContainsRune(record, '\n') {
+			return ErrorNewlineInRecord
+		}
+	}
+
+	f, err := os.OpenFile(path.Join(s.Dir, key), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = truncateTornTail(f)
+	if err != nil {
+		return err
+	}
+
+	// a single write, so that a crash tears at most the last record
+	buf := []byte{}
+	for _, record := range records {
+		buf = append(buf, record...)
+		buf = append(buf, '\n')
+	}
+	_, err = f.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// truncateTornTail cuts off a last record, that is not followed by a newline,
+// since writing it was interrupted.
+func truncateTornTail(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	tail := make([]byte, min(size, maxRecordSize+1))
+	_, err = f.ReadAt(tail, size-int64(len(tail)))
+	if err != nil {
+		return err
+	}
+	if tail[len(tail)-1] == '\n' {
+		return nil
+	}
+
+	i := bytes.LastIndexByte(tail, '\n')
+	if i < 0 && int64(len(tail)) < size {
+		// longer than any record, it could not be read back anyway
+		return nil
+	}
+	log.Printf("Dropping a torn record at the end of %s", f.Name())
+	return f.Truncate(size - int64(len(tail)) + int64(i) + 1)
+}
+
+func (s *FileStore) Records(key string, each func(record []byte) error) error {
+	f, err := os.Open(path.Join(s.Dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		} else {
+			return err
+		}
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxRecordSize)
+	scanner.Split(scanRecords)
+	for scanner.Scan() {
+		err = each(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// scanRecords splits lines like bufio.ScanLines, but skips a last line
+// without a newline. It is a record, whose write was interrupted.
+func scanRecords(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) > 0 && !bytes.Contains(data, []byte{'\n'}) {
+		log.Printf("Skipping a torn record of %d bytes", len(data))
+		return len(data), nil, nil
+	}
+	return bufio.ScanLines(data, atEOF)
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package permanence
+
+import (
+	"aphoteka_scraper/manifest"
+	"encoding/json"
+	"time"
+)
+
+// History is stored as JSON lines, so that it can be appended to without
+// reading it.
+const historyKey = "history.jsonl"
+
+// HistoryEntry is the state of a product at the moment it changed.
+type HistoryEntry struct {
+	Time         time.Time
+	Product      string
+	Availability manifest.Availability
+}
+
+// AppendHistory records the current state of the named products.
+func AppendHistory(t time.Time, m manifest.Manifest, names []string) error {
+	records := [][]byte{}
+	for _, name := range names {
+		a, ok := m[name]
+		if !ok {
+			continue
+		}
+
+		record, err := json.Marshal(HistoryEntry{Time: t, Product: name, Availability: a})
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	st, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	return st.Append(historyKey, records...)
+}
+
+// LoadHistory returns all recorded states of a product, oldest first. If
+// there is no history yet, returns an empty slice.
+func LoadHistory(product string) ([]HistoryEntry, error) {
+	st, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []HistoryEntry{}
+	err = st.Records(historyKey, func(record []byte) error {
+		entry, err := decodeHistoryLine(record)
+		if err != nil {
+			return err
+		}
+		if entry.Product == product {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,37 @@
+package permanence
+
+import (
+	"aphoteka_scraper/manifest"
+	"errors"
+)
+
+var listingsSchema = Schema{
+	Name: "last_listings.gob",
+	Migrations: []Migration{
+		migrateListingsV0,
+	},
+}
+
+// SaveListings saves the last seen content of every watched listing, by name
+// of the watch.
+func SaveListings(data map[string]manifest.Listing) error {
+	return listingsSchema.Save(data)
+}
+
+// LoadListings loads the saved listings. If there are none yet, returns an
+// empty map.
+func LoadListings() (map[string]manifest.Listing, error) {
+	var data map[string]manifest.Listing
+	err := listingsSchema.Load(&data, func() {
+		data = map[string]manifest.Listing{}
+	})
+	if err != nil {
+		if errors.Is(err, ErrorNotFound) {
+			return map[string]manifest.Listing{}, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,95 @@
+package permanence
+
+import (
+	"aphoteka_scraper/manifest"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// availabilityV0 is the layout of manifest.Availability saved before files
+// had a version: the price in uint cents with a separate currency, and the
+// stock as a schema.org url in Tag, empty when the product was not found.
+type availabilityV0 struct {
+	Price    uint
+	Tag      string
+	Url      string
+	Currency string
+}
+
+func (a availabilityV0) upgrade() manifest.Availability {
+	upgraded := manifest.Availability{
+		Price: manifest.FromCents(int64(a.Price), a.Currency),
+		Url:   a.Url,
+	}
+	if a.Tag != "" {
+		upgraded.Found = true
+		upgraded.Stock = manifest.ParseStock(a.Tag)
+	}
+	return upgraded
+}
+
+func encodeGob(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// migrateManifestV0 converts a manifest of version 0.
+func migrateManifestV0(data []byte) ([]byte, error) {
+	v0 := map[string]availabilityV0{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v0)
+	if err != nil {
+		return nil, err
+	}
+
+	m := manifest.Manifest{}
+	for name, a := range v0 {
+		m[name] = a.upgrade()
+	}
+	return encodeGob(m)
+}
+
+// migrateListingsV0 converts watched listings of version 0.
+func migrateListingsV0(data []byte) ([]byte, error) {
+	v0 := map[string]map[string]struct {
+		Name         string
+		Availability availabilityV0
+	}{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v0)
+	if err != nil {
+		return nil, err
+	}
+
+	listings := map[string]manifest.Listing{}
+	for watch, products := range v0 {
+		listing := manifest.Listing{}
+		for url, p := range products {
+			listing[url] = manifest.ListedProduct{Name: p.Name, Availability: p.Availability.upgrade()}
+		}
+		listings[watch] = listing
+	}
+	return encodeGob(listings)
+}
+
+// decodeHistoryLine reads a line of history. History is appended to and
+// never rewritten, so lines in the layout of version 0 are read as they are.
+// Json refuses a price of the wrong type, which tells the layouts apart.
+func decodeHistoryLine(line []byte) (HistoryEntry, error) {
+	var entry HistoryEntry
+	err := json.Unmarshal(line, &entry)
+	if err == nil {
+		return entry, nil
+	}
+
+	var v0 struct {
+		Time         time.Time
+		Product      string
+		Availability availabilityV0
+	}
+	if json.Unmarshal(line, &v0) != nil {
+		return HistoryEntry{}, err
+	}
+	return HistoryEntry{Time: v0.Time, Product: v0.Product, Availability: v0.Availability.upgrade()}, nil
+}
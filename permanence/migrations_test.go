@@ -0,0 +1,160 @@
+package permanence
+
+import (
+	"aphoteka_scraper/manifest"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// The files in testdata were written in the layout of version 0, with
+// prices in uint cents and the stock in Tag.
+
+var goldenParacetamols = manifest.Availability{
+	Price: manifest.Money{Cents: 435, Currency: "EUR"},
+	Found: true,
+	Stock: manifest.StockInStock,
+	Url:   "https://www.apotheka.lv/paracetamols-500mg-n20",
+}
+
+var goldenIbuprofens = manifest.Availability{
+	Price: manifest.Money{Cents: 1250, Currency: "EUR"},
+	Found: true,
+	Stock: manifest.StockOutOfStock,
+	Url:   "https://www.apotheka.lv/ibuprofens-400mg-n10",
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkAvailability(t *testing.T, name string, got, want manifest.Availability) {
+	t.Helper()
+	if !got.Identical(want) {
+		t.Errorf("%s:\ngot  %+v\nwant %+v", name, got, want)
+	}
+}
+
+func TestMigrateManifestV0(t *testing.T) {
+	m := manifest.Manifest{}
+	err := manifestSchema.Decode(readGolden(t, "manifest_v0.gob"), &m)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if len(m) != 2 {
+		t.Errorf("got %d products, want 2", len(m))
+	}
+	checkAvailability(t, "Paracetamols", m["Paracetamols"], goldenParacetamols)
+	checkAvailability(t, "Ibuprofens", m["Ibuprofens"], goldenIbuprofens)
+}
+
+func TestMigrateListingsV0(t *testing.T) {
+	listings := map[string]manifest.Listing{}
+	err := listingsSchema.Decode(readGolden(t, "listings_v0.gob"), &listings)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	listing, ok := listings["Pretsāpju"]
+	if len(listings) != 1 || !ok {
+		t.Fatalf("got watches %v, want only Pretsāpju", listings)
+	}
+	if len(listing) != 2 {
+		t.Errorf("got %d products, want 2", len(listing))
+	}
+
+	p := listing[goldenParacetamols.Url]
+	if p.Name != "Paracetamols 500mg N20" {
+		t.Errorf("got name %q", p.Name)
+	}
+	checkAvailability(t, "Paracetamols", p.Availability, goldenParacetamols)
+
+	p = listing[goldenIbuprofens.Url]
+	if p.Name != "Ibuprofēns 400mg N10" {
+		t.Errorf("got name %q", p.Name)
+	}
+	checkAvailability(t, "Ibuprofens", p.Availability, goldenIbuprofens)
+}
+
+func TestDecodeHistoryLineV0(t *testing.T) {
+	line := bytes.TrimSpace(readGolden(t, "history_v0.jsonl"))
+	entry, err := decodeHistoryLine(line)
+	if err != nil {
+		t.Fatalf("decodeHistoryLine: %v", err)
+	}
+
+	if entry.Product != "Paracetamols" {
+		t.Errorf("got product %q", entry.Product)
+	}
+	if !entry.Time.Equal(time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("got time %v", entry.Time)
+	}
+	checkAvailability(t, "Paracetamols", entry.Availability, goldenParacetamols)
+}
+
+func TestDecodeHistoryLine(t *testing.T) {
+	want := HistoryEntry{
+		Time:         time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC),
+		Product:      "Paracetamols",
+		Availability: goldenParacetamols,
+	}
+	want.Availability.Sku = "1001234"
+	line, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := decodeHistoryLine(line)
+	if err != nil {
+		t.Fatalf("decodeHistoryLine: %v", err)
+	}
+	checkAvailability(t, "Paracetamols", entry.Availability, want.Availability)
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	m := manifest.Manifest{"Paracetamols": goldenParacetamols, "Ibuprofens": goldenIbuprofens}
+
+	raw, err := manifestSchema.Encode(m)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded := manifest.Manifest{}
+	err = manifestSchema.Decode(raw, &decoded)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !manifest.AreIdentical(m, decoded) {
+		t.Errorf("got %+v, want %+v", decoded, m)
+	}
+}
+
+func TestSchemaRejectsNewerVersion(t *testing.T) {
+	data, err := encodeGob(manifest.Manifest{"Paracetamols": goldenParacetamols})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	err = gob.NewEncoder(&buf).Encode(envelope{SchemaVersion: manifestSchema.Version() + 1, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := manifest.Manifest{}
+	err = manifestSchema.Decode(buf.Bytes(), &m)
+	if !errors.Is(err, ErrorNewerSchema) {
+		t.Errorf("got %v, want %v", err, ErrorNewerSchema)
+	}
+	if len(m) != 0 {
+		t.Errorf("newer file was decoded anyway: %v", m)
+	}
+}
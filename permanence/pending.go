@@ -0,0 +1,40 @@
+package permanence
+
+import (
+	"aphoteka_scraper/manifest"
+	"errors"
+)
+
+// PendingChange is a change of a product, that has been seen Count times in
+// a row, but not often enough to be confirmed yet.
+type PendingChange struct {
+	Seen  manifest.Availability
+	Count int
+}
+
+var pendingSchema = Schema{
+	Name: "pending_changes.gob",
+}
+
+// SavePending saves the changes waiting for confirmation, by product name.
+func SavePending(data map[string]PendingChange) error {
+	return pendingSchema.Save(data)
+}
+
+// LoadPending loads the changes waiting for confirmation. If there are none
+// yet, returns an empty map.
+func LoadPending() (map[string]PendingChange, error) {
+	var data map[string]PendingChange
+	err := pendingSchema.Load(&data, func() {
+		data = map[string]PendingChange{}
+	})
+	if err != nil {
+		if errors.Is(err, ErrorNotFound) {
+			return map[string]PendingChange{}, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
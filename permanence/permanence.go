@@ -2,7 +2,7 @@ package permanence
 
 import (
 	"aphoteka_scraper/manifest"
-	"encoding/gob"
+	"errors"
 	"os"
 	"path"
 )
@@ -15,7 +15,7 @@ func GetUserDir() (string, error) {
 
 	p := path.Join(filename, "aphoteka_scraper")
 
-	err = os.MkdirAll(p, 0666)
+	err = os.MkdirAll(p, 0750)
 	if err != nil {
 		return "", err
 	}
@@ -23,67 +23,31 @@ func GetUserDir() (string, error) {
 	return p, nil
 }
 
-func getManifestFilename() (string, error) {
-	filename, err := GetUserDir()
-	if err != nil {
-		return "", err
-	}
-
-	return path.Join(filename, "last_manifest.gob"), nil
+var manifestSchema = Schema{
+	Name: "last_manifest.gob",
+	Migrations: []Migration{
+		migrateManifestV0,
+	},
 }
 
 func SaveManifest(data manifest.Manifest) error {
-	filename, err := getManifestFilename()
-	if err != nil {
-		return err
-	}
-
-	err = os.MkdirAll(path.Dir(filename), 0750)
-	if err != nil {
-		return err
-	}
-
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	w := gob.NewEncoder(f)
-
-	err = w.Encode(data)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return manifestSchema.Save(data)
 }
 
 // Load tries to load the saved manifest. If the manifest does not exist,
 // returns an empty manifest.
 func LoadManifest() (manifest.Manifest, error) {
-	filename, err := getManifestFilename()
-	if err != nil {
-		return nil, err
-	}
-
-	f, err := os.Open(filename)
+	var data manifest.Manifest
+	err := manifestSchema.Load(&data, func() {
+		data = manifest.Manifest{}
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, ErrorNotFound) {
 			return manifest.Manifest{}, nil
 		} else {
 			return nil, err
 		}
 	}
-	defer f.Close()
-
-	r := gob.NewDecoder(f)
-
-	var data manifest.Manifest
-	err = r.Decode(&data)
-	if err != nil {
-		return nil, err
-	}
 
 	return data, nil
 }
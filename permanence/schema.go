@@ -0,0 +1,118 @@
+package permanence
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var ErrorNewerSchema = errors.New("file was written by a newer version of the bot")
+
+// Migration converts gob encoded data of one schema version into the next
+// version.
+type Migration func(data []byte) ([]byte, error)
+
+// Schema describes the versions of one kind of file. Migrations[i] upgrades
+// version i to version i+1, so the current version is len(Migrations).
+// Version 0 is the plain gob dump, that was written before files had a
+// version.
+type Schema struct {
+	Name       string
+	Migrations []Migration
+}
+
+// AsIs is the migration for versions, that only added fields. Gob leaves
+// them at their zero value.
+func AsIs(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// envelope is what versioned files contain.
+type envelope struct {
+	SchemaVersion int
+	Data          []byte
+}
+
+func (s Schema) Version() int {
+	return len(s.Migrations)
+}
+
+// Encode gob encodes v and marks it with the current schema version.
+func (s Schema) Encode(v any) ([]byte, error) {
+	data := bytes.Buffer{}
+	err := gob.NewEncoder(&data).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = gob.NewEncoder(&buf).Encode(envelope{SchemaVersion: s.Version(), Data: data.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode reads a file of any version of the schema into v, running the
+// migrations needed on the way.
+func (s Schema) Decode(raw []byte, v any) error {
+	var e envelope
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&e)
+	if err != nil || e.Data == nil {
+		// written before files had a version
+		e = envelope{SchemaVersion: 0, Data: raw}
+	}
+
+	if e.SchemaVersion > s.Version() {
+		return errors.Join(
+			fmt.Errorf("%s has schema version %d, this version of the bot reads up to %d",
+				s.Name, e.SchemaVersion, s.Version()),
+			ErrorNewerSchema,
+		)
+	}
+
+	data := e.Data
+	for version := e.SchemaVersion; version < s.Version(); version++ {
+		data, err = s.Migrations[version](data)
+		if err != nil {
+			return errors.Join(
+				fmt.Errorf("cannot migrate %s from schema version %d to %d", s.Name, version, version+1),
+				err,
+			)
+		}
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Save encodes v and stores it under the name of the schema.
+func (s Schema) Save(v any) error {
+	raw, err := s.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	st, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	return st.Save(s.Name, raw)
+}
+
+// Load decodes the value stored under the name of the schema into v. Reset
+// is called before every attempt to decode, and has to put a fresh value
+// into v. Returns ErrorNotFound if nothing was saved yet.
+func (s Schema) Load(v any, reset func()) error {
+	st, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	return st.Load(s.Name, func(raw []byte) error {
+		reset()
+		return s.Decode(raw, v)
+	})
+}
@@ -0,0 +1,101 @@
+package permanence
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrorNotFound = errors.New("nothing is stored under this key")
+var ErrorUnknownStore = errors.New("unknown store, use file or bolt")
+var ErrorNewlineInRecord = errors.New("stored records cannot contain newlines")
+
+// Store keeps everything the bot remembers: the config with its alert rules
+// and subscriptions, the last manifest and listings, and the history.
+//
+// Values are replaced as a whole, records are only ever appended. Keys are
+// the names of the files the file store uses.
+type Store interface {
+	// Load passes the value stored under key to decode. Decode may be
+	// called several times, if the store has backups to try, and has to
+	// start from scratch every time. Returns ErrorNotFound if nothing is
+	// stored under key.
+	Load(key string, decode func(data []byte) error) error
+	// Save replaces the value stored under key.
+	Save(key string, data []byte) error
+	// Append adds records to the log stored under key. Records containing
+	// newlines are refused with ErrorNewlineInRecord.
+	Append(key string, records ...[]byte) error
+	// Records passes every record of the log stored under key to each,
+	// oldest first, and stops at the first error. A missing log has no
+	// records.
+	Records(key string, each func(record []byte) error) error
+	Close() error
+}
+
+const (
+	StoreFile = "file"
+	StoreBolt = "bolt"
+)
+
+var store Store
+var storeMutex sync.Mutex
+
+// OpenStore opens the store of the given kind in the user directory and
+// makes it the one all data is kept in.
+func OpenStore(kind string) error {
+	dir, err := GetUserDir()
+	if err != nil {
+		return err
+	}
+
+	var s Store
+	switch kind {
+	case StoreFile:
+		s = NewFileStore(dir)
+	case StoreBolt:
+		s, err = OpenBoltStore(dir)
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrorUnknownStore
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if store != nil {
+		store.Close()
+	}
+	store = s
+
+	return nil
+}
+
+// CloseStore closes the store opened by OpenStore.
+func CloseStore() error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	err := store.Close()
+	store = nil
+	return err
+}
+
+// getStore returns the opened store, or the file store, if none was opened.
+func getStore() (Store, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		dir, err := GetUserDir()
+		if err != nil {
+			return nil, err
+		}
+		store = NewFileStore(dir)
+	}
+
+	return store, nil
+}
@@ -0,0 +1,240 @@
+package permanence
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var stores = []struct {
+	name string
+	open func(dir string) (Store, error)
+}{
+	{StoreFile, func(dir string) (Store, error) { return NewFileStore(dir), nil }},
+	{StoreBolt, func(dir string) (Store, error) { return OpenBoltStore(dir) }},
+}
+
+// forEachStore runs test against every kind of store, each opened in a
+// directory of its own. Reopen closes the store and opens it again.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store, reopen func() Store)) {
+	for _, kind := range stores {
+		t.Run(kind.name, func(t *testing.T) {
+			dir := t.TempDir()
+			open := func() Store {
+				s, err := kind.open(dir)
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				t.Cleanup(func() { s.Close() })
+				return s
+			}
+
+			s := open()
+			test(t, s, func() Store {
+				if err := s.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+				s = open()
+				return s
+			})
+		})
+	}
+}
+
+func load(t *testing.T, s Store, key string) ([]byte, error) {
+	t.Helper()
+	var data []byte
+	err := s.Load(key, func(raw []byte) error {
+		data = bytes.Clone(raw)
+		return nil
+	})
+	return data, err
+}
+
+func records(t *testing.T, s Store, key string) []string {
+	t.Helper()
+	result := []string{}
+	err := s.Records(key, func(record []byte) error {
+		result = append(result, string(record))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Records(%q): %v", key, err)
+	}
+	return result
+}
+
+func TestStoreMissingKey(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		_, err := load(t, s, "missing.gob")
+		if !errors.Is(err, ErrorNotFound) {
+			t.Errorf("got %v, want %v", err, ErrorNotFound)
+		}
+		if got := records(t, s, "missing.jsonl"); len(got) != 0 {
+			t.Errorf("missing log has records %q", got)
+		}
+	})
+}
+
+func TestStoreSaveLoad(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		for _, value := range []string{"first", "second"} {
+			if err := s.Save("value.gob", []byte(value)); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+		}
+		if err := s.Save("other.gob", []byte("other")); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+
+		data, err := load(t, s, "value.gob")
+		if err != nil || string(data) != "second" {
+			t.Errorf("got %q, %v, want \"second\"", data, err)
+		}
+		data, err = load(t, s, "other.gob")
+		if err != nil || string(data) != "other" {
+			t.Errorf("got %q, %v, want \"other\"", data, err)
+		}
+	})
+}
+
+func TestStoreLoadPassesDecodeError(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		if err := s.Save("value.gob", []byte("value")); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		err := s.Load("value.gob", func([]byte) error { return ErrorNewerSchema })
+		if !errors.Is(err, ErrorNewerSchema) {
+			t.Errorf("got %v, want %v", err, ErrorNewerSchema)
+		}
+	})
+}
+
+func TestStoreAppendRecords(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		if err := s.Append("log.jsonl", []byte("1"), []byte("2")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := s.Append("other.jsonl", []byte("other")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := s.Append("log.jsonl", []byte("3")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+
+		if got := records(t, s, "log.jsonl"); !slices.Equal(got, []string{"1", "2", "3"}) {
+			t.Errorf("got %q, want oldest first", got)
+		}
+		if got := records(t, s, "other.jsonl"); !slices.Equal(got, []string{"other"}) {
+			t.Errorf("got %q from the other log", got)
+		}
+	})
+}
+
+func TestStoreRecordsStopsAtError(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		if err := s.Append("log.jsonl", []byte("1"), []byte("2")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+
+		stop := errors.New("stop")
+		seen := 0
+		err := s.Records("log.jsonl", func([]byte) error {
+			seen++
+			return stop
+		})
+		if !errors.Is(err, stop) || seen != 1 {
+			t.Errorf("got %v after %d records, want %v after 1", err, seen, stop)
+		}
+	})
+}
+
+func TestStoreNewlineInRecord(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		err := s.Append("log.jsonl", []byte("fine"), []byte("broken\nline"))
+		if !errors.Is(err, ErrorNewlineInRecord) {
+			t.Errorf("got %v, want %v", err, ErrorNewlineInRecord)
+		}
+		if got := records(t, s, "log.jsonl"); len(got) != 0 {
+			t.Errorf("refused append wrote %q", got)
+		}
+	})
+}
+
+func TestStoreReopen(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, reopen func() Store) {
+		if err := s.Save("value.gob", []byte("value")); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if err := s.Append("log.jsonl", []byte("1")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+
+		s = reopen()
+
+		data, err := load(t, s, "value.gob")
+		if err != nil || string(data) != "value" {
+			t.Errorf("got %q, %v after reopening", data, err)
+		}
+		if err := s.Append("log.jsonl", []byte("2")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if got := records(t, s, "log.jsonl"); !slices.Equal(got, []string{"1", "2"}) {
+			t.Errorf("got %q after reopening", got)
+		}
+	})
+}
+
+func TestBoltStoreImportsFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := NewFileStore(dir)
+	if err := files.Save("value.gob", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.Append("log.jsonl", []byte("1"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenBoltStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	defer s.Close()
+
+	data, err := load(t, s, "value.gob")
+	if err != nil || string(data) != "value" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if got := records(t, s, "log.jsonl"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+// A crash while appending leaves the last record without its newline.
+func TestFileStoreTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	if err := s.Append("log.jsonl", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "log.jsonl"), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"torn`)
+	f.Close()
+
+	if got := records(t, s, "log.jsonl"); !slices.Equal(got, []string{"1"}) {
+		t.Errorf("got %q, want the torn record skipped", got)
+	}
+
+	if err := s.Append("log.jsonl", []byte("2")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if got := records(t, s, "log.jsonl"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("got %q, want the torn record dropped", got)
+	}
+}
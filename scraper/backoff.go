@@ -0,0 +1,120 @@
+package scraper
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of failed checks in a row after which a domain is reported as
+// degraded.
+const degradedAfter = 2
+
+// Upper bound for how long a failing domain is left alone.
+const maxBackoff = 24 * time.Hour
+
+// DomainStatus describes a domain that started failing.
+type DomainStatus struct {
+	Domain  string
+	RetryAt time.Time
+}
+
+// domainHealth tracks how a single shop domain has been responding.
+type domainHealth struct {
+	failures int
+	retryAt  time.Time
+	degraded bool
+}
+
+var health = map[string]*domainHealth{}
+var healthMutex sync.Mutex
+
+func domainOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// isDue reports whether the domain may be queried now. Half an interval of
+// slack is allowed, since the ticker never fires exactly on time.
+func isDue(domain string, now time.Time, interval time.Duration) bool {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	h, ok := health[domain]
+	if !ok {
+		return true
+	}
+	return now.Add(interval / 2).After(h.retryAt)
+}
+
+// isThrottled reports whether the status code means the shop is overloaded or
+// rate-limiting us.
+func isThrottled(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// parseRetryAfter understands both forms of the Retry-After header: delay in
+// seconds and an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// recordCheck updates the health of a domain after a check. Returns whether
+// the domain just became degraded or just recovered.
+func recordCheck(domain string, failed bool, retryAfter time.Duration, now time.Time, interval time.Duration) (degraded, recovered bool, retryAt time.Time) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	h, ok := health[domain]
+	if !ok {
+		h = &domainHealth{}
+		health[domain] = h
+	}
+
+	if !failed {
+		recovered = h.degraded
+		*h = domainHealth{}
+		return
+	}
+
+	h.failures++
+
+	delay := interval
+	for i := 0; i < h.failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	h.retryAt = now.Add(delay)
+
+	if h.failures >= degradedAfter && !h.degraded {
+		h.degraded = true
+		degraded = true
+	}
+
+	return degraded, false, h.retryAt
+}
+
+func isDegraded(domain string) bool {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	h, ok := health[domain]
+	return ok && h.degraded
+}
@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"maps"
+	"sync"
+)
+
+// ConfirmRule holds back notifications about a product until a change has
+// been seen in several checks in a row.
+type ConfirmRule struct {
+	// Number of checks in a row that must agree on the change.
+	Checks int
+	// Price changes up to this many percent do not need to be confirmed.
+	PriceChange float64
+}
+
+// Changes waiting for confirmation are kept on disk, so that a restart does
+// not start counting from scratch. They are loaded on first use.
+var pending map[string]permanence.PendingChange
+var pendingMutex sync.Mutex
+
+// needsConfirmation reports whether the change from prev to next is one the
+// rule cares about: any stock change or a large enough price change.
+func (r ConfirmRule) needsConfirmation(prev, next manifest.Availability) bool {
+	if prev.Found != next.Found || prev.Stock != next.Stock {
+		return true
+	}
+	if prev.Price.IsZero() || prev.Price == next.Price {
+		return false
+	}
+
+	diff := next.Price.Float() - prev.Price.Float()
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff/prev.Price.Float()*100 > r.PriceChange
+}
+
+// confirmChanges replaces every change of a fetched product in m, that is
+// not yet confirmed, with the previous state of the product. Returns the
+// names of products, which are waiting for confirmation.
+func confirmChanges(prev, m manifest.Manifest, fetched []string, rules map[string]ConfirmRule) ([]string, error) {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	if pending == nil {
+		loaded, err := permanence.LoadPending()
+		if err != nil {
+			return nil, err
+		}
+		pending = loaded
+	}
+
+	unconfirmed := []string{}
+	before := maps.Clone(pending)
+
+	for _, name := range fetched {
+		next, ok := m[name]
+		if !ok {
+			continue
+		}
+		rule, ok := rules[name]
+		old, known := prev[name]
+		if !ok || rule.Checks <= 1 || !known || !rule.needsConfirmation(old, next) {
+			delete(pending, name)
+			continue
+		}
+
+		p, ok := pending[name]
+		if !ok || !p.Seen.Equal(next) {
+			p = permanence.PendingChange{Seen: next}
+		}
+		p.Count++
+		pending[name] = p
+
+		if p.Count >= rule.Checks {
+			delete(pending, name)
+			continue
+		}
+
+		m[name] = old
+		unconfirmed = append(unconfirmed, name)
+	}
+
+	if maps.EqualFunc(before, pending, func(a, b permanence.PendingChange) bool {
+		return a.Count == b.Count && a.Seen.Identical(b.Seen)
+	}) {
+		return unconfirmed, nil
+	}
+	return unconfirmed, permanence.SavePending(pending)
+}
@@ -0,0 +1,33 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrorEmptyData = errors.New("empty data")
+var ErrorCannotParse = errors.New("cannot parse product data")
+var ErrorNoProductData = errors.New("no product data found on the page")
+var ErrorInvalidUrl = errors.New("not a valid web address")
+var ErrorUnsupportedShop = errors.New("no registered shop supports this domain")
+var ErrorEmptySitemap = errors.New("sitemap contains no pages")
+var ErrorEmptyQuery = errors.New("search query contains no words")
+var ErrorEmptyListing = errors.New("no products found on the page")
+var ErrorProductGone = errors.New("product page no longer exists")
+var ErrorOtherProduct = errors.New("page shows a different product")
+var ErrorBackingOff = errors.New("shop is backing off after failed checks")
+
+// ProductError is an error that happened while fetching a single product.
+type ProductError struct {
+	Product string
+	Url     string
+	Err     error
+}
+
+func (e *ProductError) Error() string {
+	return fmt.Sprintf("product %q (%s): %v", e.Product, e.Url, e.Err)
+}
+
+func (e *ProductError) Unwrap() error {
+	return e.Err
+}
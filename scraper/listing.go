@@ -0,0 +1,243 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+// At most this many pages of a single listing are followed.
+const maxListingPages = 20
+
+// At most this many products are fetched one by one, when a listing page does
+// not describe them itself.
+const maxListingProducts = 100
+
+// Links to the next page of a listing.
+const nextPageXPath = `//link[@rel="next"]/@href | //a[@rel="next"]/@href`
+
+// Links inside product cards of the product grid, used when a listing has no
+// product data. Classes are matched as whole words, so that menus and filters
+// named e.g. "products-menu" do not count, and navigation is left out.
+const productLinkXPath = `//*[contains(concat(" ", normalize-space(@class), " "), " product-item ") or contains(concat(" ", normalize-space(@class), " "), " product-card ")][not(ancestor::nav or ancestor::header or ancestor::footer or ancestor::aside)]//a/@href`
+
+// linkSet keeps links in the order they were found on the pages.
+type linkSet struct {
+	order []string
+	seen  map[string]bool
+}
+
+func newLinkSet() *linkSet {
+	return &linkSet{seen: map[string]bool{}}
+}
+
+func (s *linkSet) add(u string) {
+	if u == "" || s.seen[u] {
+		return
+	}
+	s.seen[u] = true
+	s.order = append(s.order, u)
+}
+
+// walkListingData looks for products in JSON-LD data of a listing page. Full
+// products are added to listing, while list items that only name a url are
+// added to links.
+func walkListingData(v any, base *url.URL, listing manifest.Listing, links *linkSet) {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			walkListingData(item, base, listing, links)
+		}
+
+	case map[string]any:
+		switch v["@type"] {
+		case "Product":
+			u := resolveListingUrl(base, v["url"])
+			if u == "" {
+				u = resolveListingUrl(base, v["@id"])
+			}
+			if u == "" {
+				return
+			}
+			name, _ := v["name"].(string)
+			listing[u] = manifest.ListedProduct{
+				Name:         name,
+				Availability: listedAvailability(v["offers"], u),
+			}
+			return
+
+		case "ListItem":
+			if _, ok := v["item"].(map[string]any); !ok {
+				links.add(resolveListingUrl(base, v["url"]))
+				links.add(resolveListingUrl(base, v["item"]))
+				return
+			}
+		}
+
+		for _, item := range v {
+			walkListingData(item, base, listing, links)
+		}
+	}
+}
+
+func resolveListingUrl(base *url.URL, v any) string {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return ""
+	}
+	u, err := base.Parse(s)
+	if err != nil {
+		return ""
+	}
+	normalized, err := NormalizeUrl(u.String())
+	if err != nil {
+		return ""
+	}
+	return normalized
+}
+
+// listedAvailability reads offers of a product, given either as a single
+// offer or as a list of them.
+func listedAvailability(offers any, u string) manifest.Availability {
+	if list, ok := offers.([]any); ok && len(list) > 0 {
+		offers = list[0]
+	}
+
+	offer, _ := offers.(map[string]any)
+	availability, _ := offer["availability"].(string)
+	currency, _ := offer["priceCurrency"].(string)
+
+	// prices are given both as numbers and as strings
+	var price manifest.Money
+	switch p := offer["price"].(type) {
+	case json.Number:
+		price, _ = manifest.ParseMoney(p.String(), currency)
+	case string:
+		price, _ = manifest.ParseMoney(p, currency)
+	}
+
+	return manifest.Availability{
+		Price: price,
+		Found: true,
+		Stock: manifest.ParseStock(availability),
+		Url:   u,
+		Shop:  shopName(u),
+	}
+}
+
+// FetchListing reads a category page or search results of a shop, following
+// pagination, and returns all products on it. Products are taken from the
+// JSON-LD data of the pages, if there is any. Otherwise links of product
+// cards are fetched one by one, in the order of the pages.
+//
+// Products of prev, the listing seen last time, are kept when they could not
+// be read this time: a product page failed, it was over the limit of pages
+// fetched one by one, or a later page of the listing failed. Returns
+// ErrorBackingOff, while the shop is backing off after failed checks.
+func FetchListing(listUrl string, prev manifest.Listing, interval time.Duration) (manifest.Listing, error) {
+	listUrl, err := NormalizeUrl(listUrl)
+	if err != nil {
+		return nil, err
+	}
+	if !isDue(domainOf(listUrl), time.Now(), interval) {
+		return nil, ErrorBackingOff
+	}
+
+	c := newCollector()
+
+	listing := manifest.Listing{}
+	links := newLinkSet()
+	cards := newLinkSet()
+	pages := 0
+	incomplete := false
+	e := []error{}
+
+	c.OnRequest(func(r *colly.Request) {
+		if pages >= maxListingPages {
+			r.Abort()
+			return
+		}
+		pages++
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		log.Printf("Cannot read listing page %s: %v", r.Request.URL, err)
+		incomplete = true
+	})
+
+	c.OnXML(ldJsonXPath, func(x *colly.XMLElement) {
+		var data any
+		dec := json.NewDecoder(strings.NewReader(x.Text))
+		dec.UseNumber()
+		err := dec.Decode(&data)
+		if err != nil {
+			e = append(e, errors.Join(ErrorCannotParse, err))
+			incomplete = true
+			return
+		}
+		walkListingData(data, x.Request.URL, listing, links)
+	})
+
+	c.OnXML(productLinkXPath, func(x *colly.XMLElement) {
+		cards.add(resolveListingUrl(x.Request.URL, strings.TrimSpace(x.Text)))
+	})
+
+	c.OnXML(nextPageXPath, func(x *colly.XMLElement) {
+		x.Request.Visit(strings.TrimSpace(x.Text))
+	})
+
+	err = c.Visit(listUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// list items without product data, or else the product cards
+	if len(listing) == 0 && len(links.order) == 0 {
+		links = cards
+	}
+
+	fetched := 0
+	for _, u := range links.order {
+		if _, ok := listing[u]; ok || u == listUrl {
+			continue
+		}
+		if fetched >= maxListingProducts {
+			if p, ok := prev[u]; ok {
+				listing[u] = p
+			}
+			continue
+		}
+		fetched++
+
+		info, err := FetchProduct(u)
+		if err != nil {
+			if p, ok := prev[u]; ok {
+				listing[u] = p
+			}
+			continue
+		}
+		listing[u] = manifest.ListedProduct{Name: info.Name, Availability: info.Availability}
+	}
+
+	// products on the pages, that could not be read, are not gone
+	if incomplete {
+		for u, p := range prev {
+			if _, ok := listing[u]; !ok {
+				listing[u] = p
+			}
+		}
+	}
+
+	if len(listing) == 0 {
+		e = append(e, ErrorEmptyListing)
+		return nil, errors.Join(e...)
+	}
+
+	return listing, nil
+}
@@ -0,0 +1,42 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pack sizes as they are written in product names, in order of preference.
+// "N20" is the usual way to write the number of tablets or capsules. Doses
+// like "500 mg" are not pack sizes and do not match.
+var packPatterns = []struct {
+	pattern *regexp.Regexp
+	unit    string
+}{
+	{regexp.MustCompile(`(?i)\bN\s?(\d+)\b`), "pcs"},
+	{regexp.MustCompile(`(?i)\b(\d+)\s*(?:gab|tab|tabl|tabletes|kaps|kapsulas|caps|pcs)\b\.?`), "pcs"},
+	{regexp.MustCompile(`(?i)\b(\d+(?:[.,]\d+)?)\s*(ml|l|g|kg)\b`), ""},
+}
+
+// parsePack reads the pack size from the name of a product.
+func parsePack(name string) manifest.Pack {
+	for _, p := range packPatterns {
+		match := p.pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		size, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", "."), 64)
+		if err != nil {
+			continue
+		}
+		unit := p.unit
+		if unit == "" {
+			unit = match[2]
+		}
+		if pack, ok := manifest.ParsePack(size, unit); ok {
+			return pack
+		}
+	}
+	return manifest.Pack{}
+}
@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// priceSpecification is one of the prices an offer may list next to its
+// main price: the crossed-out regular price, or a price for loyalty card
+// holders.
+type priceSpecification struct {
+	Price              identifier      `json:"price"`
+	PriceType          string          `json:"priceType"`
+	ValidThrough       string          `json:"validThrough"`
+	ValidForMemberTier json.RawMessage `json:"validForMemberTier"`
+}
+
+// parsePriceSpecifications accepts both a single specification and a list.
+func parsePriceSpecifications(raw json.RawMessage) []priceSpecification {
+	if len(raw) == 0 {
+		return nil
+	}
+	var list []priceSpecification
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var single priceSpecification
+	if json.Unmarshal(raw, &single) == nil {
+		return []priceSpecification{single}
+	}
+	return nil
+}
+
+// parseDate reads dates given either as a day or as a full timestamp.
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.DateOnly, time.RFC3339, "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// applyPrices fills in the regular and loyalty prices and the end of a
+// promotion. A regular price is only kept, if it is above the actual one.
+func applyPrices(a *manifest.Availability, specs []priceSpecification, validUntil string) {
+	a.PriceValidUntil = parseDate(validUntil)
+
+	for _, spec := range specs {
+		price, err := manifest.ParseMoney(string(spec.Price), a.Price.Currency)
+		if err != nil || price.IsZero() {
+			continue
+		}
+
+		priceType := spec.PriceType
+		if i := strings.LastIndex(priceType, "/"); i >= 0 {
+			priceType = priceType[i+1:]
+		}
+
+		switch {
+		case len(spec.ValidForMemberTier) > 0 && string(spec.ValidForMemberTier) != "null":
+			if a.LoyaltyPrice.IsZero() || price.Cents < a.LoyaltyPrice.Cents {
+				a.LoyaltyPrice = price
+			}
+		case priceType == "StrikethroughPrice" || priceType == "ListPrice":
+			if price.Cents > a.Price.Cents {
+				a.RegularPrice = price
+			}
+		case priceType == "SalePrice" || priceType == "":
+			if a.PriceValidUntil.IsZero() {
+				a.PriceValidUntil = parseDate(spec.ValidThrough)
+			}
+		}
+	}
+
+	if a.RegularPrice.IsZero() {
+		a.PriceValidUntil = time.Time{}
+	}
+}
@@ -0,0 +1,132 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gocolly/colly"
+)
+
+const ldJsonXPath = `//script[@type="application/ld+json"]`
+
+// ProductInfo is what a product page tells about the product.
+type ProductInfo struct {
+	Name         string
+	Availability manifest.Availability
+}
+
+func newCollector() *colly.Collector {
+	return colly.NewCollector(
+		colly.AllowedDomains(allowedDomains()...),
+		colly.UserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:129.0) Gecko/20100101 Firefox/129.0"),
+	)
+}
+
+// identifier is a SKU, a GTIN or a price, which shops give both as strings
+// and as numbers.
+type identifier string
+
+func (id *identifier) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*id = identifier(strings.TrimSpace(s))
+		return nil
+	}
+	var n json.Number
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return err
+	}
+	*id = identifier(n.String())
+	return nil
+}
+
+// parseProductData reads the JSON-LD product data embedded into a page.
+func parseProductData(text string) (ProductInfo, error) {
+	var data []struct {
+		Name   string          `json:"name"`
+		Sku    identifier      `json:"sku"`
+		Gtin   identifier      `json:"gtin"`
+		Gtin8  identifier      `json:"gtin8"`
+		Gtin12 identifier      `json:"gtin12"`
+		Gtin13 identifier      `json:"gtin13"`
+		Gtin14 identifier      `json:"gtin14"`
+		Offers json.RawMessage `json:"offers"`
+	}
+	err := json.Unmarshal([]byte(text), &data)
+	if err != nil {
+		return ProductInfo{}, errors.Join(ErrorCannotParse, err)
+	}
+	if len(data) == 0 {
+		return ProductInfo{}, ErrorEmptyData
+	}
+
+	product := data[0]
+	main, stores := splitOffers(parseOffers(product.Offers))
+	gtin := ""
+	for _, g := range []identifier{product.Gtin13, product.Gtin, product.Gtin14, product.Gtin12, product.Gtin8} {
+		if g != "" {
+			gtin = string(g)
+			break
+		}
+	}
+
+	info := ProductInfo{
+		Name: product.Name,
+		Availability: manifest.Availability{
+			Found: true,
+			Stock: manifest.ParseStock(main.Availability),
+			Sku:   string(product.Sku),
+			Gtin:  gtin,
+			Pack:  parsePack(product.Name),
+		},
+	}
+	// a product without an offer has no price, rather than an invalid one
+	if main.Price != "" {
+		info.Availability.Price, err = manifest.ParseMoney(string(main.Price), main.PriceCurrency)
+		if err != nil {
+			return ProductInfo{}, errors.Join(ErrorCannotParse, err)
+		}
+	}
+	applyPrices(&info.Availability, parsePriceSpecifications(main.PriceSpecification), main.PriceValidUntil)
+	if len(stores) > 0 {
+		info.Availability.Stores = stores
+	}
+
+	return info, nil
+}
+
+// FetchProduct fetches a single product page, e.g. to show it to the user
+// before it is tracked.
+func FetchProduct(url string) (ProductInfo, error) {
+	c := newCollector()
+
+	var info ProductInfo
+	found := false
+	e := []error{}
+
+	c.OnXML(ldJsonXPath, func(x *colly.XMLElement) {
+		i, err := parseProductData(x.Text)
+		if err != nil {
+			e = append(e, err)
+			return
+		}
+		i.Availability.Url = url
+		i.Availability.Shop = shopName(url)
+		info = i
+		found = true
+	})
+
+	err := c.Visit(url)
+	if err != nil {
+		return info, err
+	}
+	if !found {
+		e = append(e, ErrorNoProductData)
+		return info, errors.Join(e...)
+	}
+
+	return info, nil
+}
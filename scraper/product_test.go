@@ -0,0 +1,44 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"errors"
+	"testing"
+)
+
+func TestParseProductDataPrice(t *testing.T) {
+	tests := []struct {
+		name  string
+		offer string
+		want  manifest.Money
+	}{
+		{"number", `{"price":4.35,"priceCurrency":"EUR"}`, manifest.Money{Cents: 435, Currency: "EUR"}},
+		{"string", `{"price":"4.35","priceCurrency":"EUR"}`, manifest.Money{Cents: 435, Currency: "EUR"}},
+		{"decimal comma", `{"price":"4,35","priceCurrency":"EUR"}`, manifest.Money{Cents: 435, Currency: "EUR"}},
+		{"integer", `{"price":12,"priceCurrency":"EUR"}`, manifest.Money{Cents: 1200, Currency: "EUR"}},
+		{"rounded", `{"price":"2.345","priceCurrency":"EUR"}`, manifest.Money{Cents: 235, Currency: "EUR"}},
+		{"list of offers", `[{"price":"1,99","priceCurrency":"EUR"}]`, manifest.Money{Cents: 199, Currency: "EUR"}},
+		{"no price", `{"priceCurrency":"EUR"}`, manifest.Money{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := parseProductData(`[{"name":"Paracetamols","offers":` + test.offer + `}]`)
+			if err != nil {
+				t.Fatalf("parseProductData: %v", err)
+			}
+			if info.Availability.Price != test.want {
+				t.Errorf("got %+v, want %+v", info.Availability.Price, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProductDataInvalidPrice(t *testing.T) {
+	for _, price := range []string{`"1/3"`, `"1e3"`, `1e3`, `"-4.35"`, `"free"`} {
+		_, err := parseProductData(`[{"name":"Paracetamols","offers":{"price":` + price + `,"priceCurrency":"EUR"}}]`)
+		if !errors.Is(err, ErrorCannotParse) {
+			t.Errorf("price %s: got %v, want %v", price, err, ErrorCannotParse)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"net/url"
+	"sort"
+)
+
+// How many of the most similar sitemap pages are fetched, when looking for a
+// product that has moved.
+const relocateCandidates = 5
+
+// identifiedAs tells whether a carries the same GTIN or SKU as known. Unlike
+// manifest.SameProduct, a page that cannot be told apart does not count.
+func identifiedAs(a, known manifest.Availability) bool {
+	sameGtin := a.Gtin != "" && known.Gtin != "" && manifest.SameProduct(
+		manifest.Availability{Gtin: a.Gtin}, manifest.Availability{Gtin: known.Gtin})
+	sameSku := a.Sku != "" && a.Sku == known.Sku
+	return sameGtin || sameSku && (a.Gtin == "" || known.Gtin == "")
+}
+
+// relocate looks for a product, whose page is gone, in the sitemap of its
+// shop. Pages with the most words in common with the old address are
+// fetched, and the first one showing the same SKU or GTIN is taken. Products
+// without a known SKU or GTIN cannot be found this way.
+func relocate(oldUrl string, known manifest.Availability) (string, ProductInfo, error) {
+	if known.Sku == "" && known.Gtin == "" {
+		return "", ProductInfo{}, ErrorProductGone
+	}
+
+	parsed, err := url.Parse(oldUrl)
+	if err != nil {
+		return "", ProductInfo{}, ErrorInvalidUrl
+	}
+	shop := ShopFor(parsed.Hostname())
+	if shop == nil {
+		return "", ProductInfo{}, ErrorUnsupportedShop
+	}
+
+	urls, err := shopSitemap(shop)
+	if err != nil {
+		return "", ProductInfo{}, err
+	}
+
+	oldWords := map[string]bool{}
+	for _, word := range searchTokens(parsed.Path) {
+		oldWords[word] = true
+	}
+
+	type candidate struct {
+		url    string
+		common int
+	}
+	candidates := []candidate{}
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		if err != nil || u == oldUrl {
+			continue
+		}
+		common := 0
+		for _, word := range searchTokens(p.Path) {
+			if oldWords[word] {
+				common++
+			}
+		}
+		if common > 0 {
+			candidates = append(candidates, candidate{url: u, common: common})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].common > candidates[j].common
+	})
+	if len(candidates) > relocateCandidates {
+		candidates = candidates[:relocateCandidates]
+	}
+
+	for _, c := range candidates {
+		info, err := FetchProduct(c.url)
+		if err != nil {
+			continue
+		}
+		if identifiedAs(info.Availability, known) {
+			newUrl, err := NormalizeUrl(c.url)
+			if err != nil {
+				newUrl = c.url
+			}
+			return newUrl, info, nil
+		}
+	}
+
+	return "", ProductInfo{}, ErrorProductGone
+}
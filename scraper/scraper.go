@@ -2,78 +2,199 @@ package scraper
 
 import (
 	"aphoteka_scraper/manifest"
-	"encoding/json"
 	"errors"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/gocolly/colly"
 )
 
-func FetchData(input map[string]string) (manifest.Manifest, error) {
-	var e []error
+// Report collects things noticed during a fetch, that are not errors.
+type Report struct {
+	Degraded  []DomainStatus
+	Recovered []string
+	// Products with a change that still has to be confirmed by another check.
+	Unconfirmed []string
+	// Manifest of the previous check, which the new one was compared to.
+	Previous manifest.Manifest
+	// Products, that the shop has moved to another url.
+	Moved []Move
+	// Domains, that were not checked this time, since they are backing off.
+	Skipped []string
+	// Products, that were fetched from a domain, which is not degraded, and
+	// were not throttled. Only their errors tell whether a problem is still
+	// there.
+	Fetched []string
+}
+
+// Move is a product found at a new url, either by following a redirect or by
+// looking up its SKU or GTIN after the old page was gone.
+type Move struct {
+	Product string
+	From    string
+	To      string
+}
 
-	c := colly.NewCollector(
-		colly.AllowedDomains("www.apotheka.lv"),
-		colly.UserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:129.0) Gecko/20100101 Firefox/129.0"),
-	)
+// FetchData scrapes every url in input. Products on domains that are backing
+// off after repeated failures are skipped and keep their state from known, as
+// do products whose request was throttled or could not reach the shop.
+//
+// Results are matched to products by name, the url only locates the page.
+// When a page redirects elsewhere or is gone, the product is accepted at its
+// new url, as long as its SKU or GTIN matches the one in known.
+func FetchData(input map[string]string, known manifest.Manifest, interval time.Duration) (manifest.Manifest, Report, error) {
+	var report Report
+	e := map[string][]error{}
+	now := time.Now()
 
-	const XPATH = `//script[@type="application/ld+json"]`
+	c := newCollector()
+	// several products may share a page, and redirects may lead to one
+	c.AllowURLRevisit = true
 
 	available := make(map[string]manifest.Availability)
+	gone := map[string]bool{}
+	throttled := map[string]bool{}
+	requests := map[string]int{}
+	failures := map[string]int{}
+	retryAfter := map[string]time.Duration{}
 
-	c.OnXML(XPATH, func(x *colly.XMLElement) {
-		var data []struct {
-			Offers struct {
-				Availability  string  `json:"availability"`
-				Price         float64 `json:"price"`
-				PriceCurrency string  `json:"priceCurrency"`
-			} `json:"offers"`
-		}
-		err := json.Unmarshal([]byte(x.Text), &data)
+	c.OnXML(ldJsonXPath, func(x *colly.XMLElement) {
+		name := x.Request.Ctx.Get("product")
+		requested := x.Request.Ctx.Get("url")
+		domain := domainOf(requested)
+		info, err := parseProductData(x.Text)
 		if err != nil {
-			e = append(e, errors.Join(
-				errors.New("when parsing url "+x.Request.URL.String()),
-				err,
-			))
+			e[domain] = append(e[domain], &ProductError{
+				Product: name,
+				Url:     x.Request.URL.String(),
+				Err:     err,
+			})
 			return
 		}
-		if len(data) == 0 {
-			e = append(e, errors.Join(
-				errors.New("when parsing url "+x.Request.URL.String()),
-				errors.New("empty data"),
-			))
-			return
+
+		a := info.Availability
+		a.Url = requested
+		a.Shop = shopName(requested)
+		final, err := NormalizeUrl(x.Request.URL.String())
+		if err != nil {
+			final = x.Request.URL.String()
 		}
-		available[x.Request.URL.String()] = manifest.Availability{
-			Price:    uint(data[0].Offers.Price * 100),
-			Tag:      data[0].Offers.Availability,
-			Currency: data[0].Offers.PriceCurrency,
+		if normalized, err := NormalizeUrl(requested); err == nil && final != normalized {
+			if !manifest.SameProduct(known[name], a) {
+				e[domain] = append(e[domain], &ProductError{Product: name, Url: final, Err: ErrorOtherProduct})
+				return
+			}
+			a.Url = final
 		}
-
+		available[name] = a
 	})
 
 	c.OnRequest(func(r *colly.Request) {
 		log.Print("Visiting ", r.URL)
+		requests[r.URL.Hostname()]++
 	})
 
-	for _, url := range input {
-		err := c.Visit(url)
+	c.OnError(func(r *colly.Response, err error) {
+		if r.StatusCode == http.StatusNotFound || r.StatusCode == http.StatusGone {
+			gone[r.Request.Ctx.Get("product")] = true
+		}
+		// no status code means the shop could not be reached at all
+		if r.StatusCode != 0 && !isThrottled(r.StatusCode) {
+			return
+		}
+		throttled[r.Request.Ctx.Get("product")] = true
+		domain := r.Request.URL.Hostname()
+		failures[domain]++
+		if r.Headers != nil {
+			d := parseRetryAfter(r.Headers.Get("Retry-After"), now)
+			retryAfter[domain] = max(retryAfter[domain], d)
+		}
+	})
+
+	skipped := map[string]bool{}
+	for name, url := range input {
+		domain := domainOf(url)
+		if !isDue(domain, now, interval) {
+			if !skipped[domain] {
+				report.Skipped = append(report.Skipped, domain)
+			}
+			skipped[domain] = true
+			continue
+		}
+
+		ctx := colly.NewContext()
+		ctx.Put("product", name)
+		ctx.Put("url", url)
+		err := c.Request("GET", url, nil, ctx, nil)
+		if err != nil && !gone[name] {
+			e[domain] = append(e[domain], &ProductError{Product: name, Url: url, Err: err})
+		}
+	}
+
+	for domain, total := range requests {
+		failed := failures[domain]*2 > total
+		degraded, recovered, retryAt := recordCheck(domain, failed, retryAfter[domain], now, interval)
+		if degraded {
+			report.Degraded = append(report.Degraded, DomainStatus{Domain: domain, RetryAt: retryAt})
+		}
+		if recovered {
+			report.Recovered = append(report.Recovered, domain)
+		}
+	}
+
+	// a shop that is failing is not searched for moved products either, they
+	// keep their state until it answers again
+	for name := range gone {
+		url := input[name]
+		domain := domainOf(url)
+		if !isDue(domain, now, interval) {
+			if a, ok := known[name]; ok {
+				available[name] = a
+			}
+			continue
+		}
+		newUrl, info, err := relocate(url, known[name])
 		if err != nil {
-			e = append(e, err)
+			e[domain] = append(e[domain], &ProductError{Product: name, Url: url, Err: err})
+			continue
 		}
+		info.Availability.Url = newUrl
+		available[name] = info.Availability
 	}
 
 	manifest := make(manifest.Manifest)
 	for name, url := range input {
-		v, ok := available[url]
+		if !skipped[domainOf(url)] && !isDegraded(domainOf(url)) && !throttled[name] {
+			report.Fetched = append(report.Fetched, name)
+		}
+		if skipped[domainOf(url)] || throttled[name] {
+			if a, ok := known[name]; ok {
+				manifest[name] = a
+			}
+			continue
+		}
+
+		v, ok := available[name]
 		if !ok {
 			log.Printf("Invalid url: %v", url)
+			v.Url = url
+		} else if v.Url != url {
+			log.Printf("Product %q moved from %v to %v", name, url, v.Url)
+			report.Moved = append(report.Moved, Move{Product: name, From: url, To: v.Url})
 		}
 
-		v.Url = url
-
 		manifest[name] = v
 	}
 
-	return manifest, errors.Join(e...)
+	// a degraded domain is reported once, not every product on every check
+	ers := []error{}
+	for domain, domainErrors := range e {
+		if isDegraded(domain) {
+			continue
+		}
+		ers = append(ers, domainErrors...)
+	}
+
+	return manifest, report, errors.Join(ers...)
 }
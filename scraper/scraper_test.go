@@ -0,0 +1,43 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchDataKeepsThrottledProducts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	oldShops, oldScheme := shops, shopScheme
+	// colly matches the port, urls are looked up without it
+	shops = []*Shop{{Name: "Test", Domains: []string{host, strings.Split(host, ":")[0]}}}
+	shopScheme = "http"
+	defer func() {
+		shops, shopScheme = oldShops, oldScheme
+		delete(health, strings.Split(host, ":")[0])
+	}()
+
+	url := server.URL + "/produkti/paracetamols-500mg-tabletes-n20"
+	known := manifest.Manifest{"Paracetamols": {
+		Price: manifest.Money{Cents: 435, Currency: "EUR"},
+		Found: true,
+		Stock: manifest.StockInStock,
+		Url:   url,
+	}}
+
+	m, report, _ := FetchData(map[string]string{"Paracetamols": url}, known, time.Hour)
+	if !m["Paracetamols"].Identical(known["Paracetamols"]) {
+		t.Errorf("got %+v, want the known state %+v", m["Paracetamols"], known["Paracetamols"])
+	}
+	if len(report.Fetched) != 0 {
+		t.Errorf("throttled products count as fetched: %v", report.Fetched)
+	}
+}
@@ -0,0 +1,232 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+// How long a downloaded sitemap is reused.
+const sitemapTTL = 12 * time.Hour
+
+// Only this many of the best matching pages are fetched to find products.
+const searchCandidates = 20
+
+type sitemapCache struct {
+	urls    []string
+	fetched time.Time
+}
+
+var sitemaps = map[*Shop]*sitemapCache{}
+var sitemapsMutex sync.Mutex
+
+// Latvian letters are folded to plain latin, since urls usually do not
+// contain them, while people typing queries may.
+var foldLetters = strings.NewReplacer(
+	"ā", "a", "č", "c", "ē", "e", "ģ", "g", "ī", "i", "ķ", "k",
+	"ļ", "l", "ņ", "n", "š", "s", "ū", "u", "ž", "z",
+)
+
+func searchTokens(s string) []string {
+	s = foldLetters.Replace(strings.ToLower(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	})
+}
+
+// The largest sitemap allowed by the sitemap protocol, uncompressed.
+const maxSitemapSize = 50 << 20
+
+// sitemap is either a sitemap index, listing other sitemaps, or a list of
+// pages.
+type sitemap struct {
+	Sitemaps []string `xml:"sitemap>loc"`
+	Urls     []string `xml:"url>loc"`
+}
+
+// parseSitemap reads a sitemap, which may be gzipped, as .xml.gz files are.
+func parseSitemap(body []byte) (sitemap, error) {
+	var r io.Reader = bytes.NewReader(body)
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return sitemap{}, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var s sitemap
+	err := xml.NewDecoder(io.LimitReader(r, maxSitemapSize)).Decode(&s)
+	return s, err
+}
+
+// fetchSitemap collects all page urls of a shop. Sitemaps are looked up in
+// robots.txt, falling back to /sitemap.xml, and sitemap indexes are followed.
+func fetchSitemap(shop *Shop) ([]string, error) {
+	c := newCollector()
+	c.MaxBodySize = 0
+
+	urls := []string{}
+	declared := false
+	e := []error{}
+
+	c.OnResponse(func(r *colly.Response) {
+		if r.Request.URL.Path == "/robots.txt" {
+			scanner := bufio.NewScanner(bytes.NewReader(r.Body))
+			for scanner.Scan() {
+				key, value, ok := strings.Cut(scanner.Text(), ":")
+				if ok && strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+					declared = true
+					r.Request.Visit(strings.TrimSpace(value))
+				}
+			}
+			return
+		}
+
+		s, err := parseSitemap(r.Body)
+		if err != nil {
+			e = append(e, fmt.Errorf("sitemap %s: %w", r.Request.URL, err))
+			return
+		}
+		for _, loc := range s.Sitemaps {
+			r.Request.Visit(strings.TrimSpace(loc))
+		}
+		for _, loc := range s.Urls {
+			urls = append(urls, strings.TrimSpace(loc))
+		}
+	})
+
+	base := shopScheme + "://" + shop.Domains[0]
+	err := c.Visit(base + "/robots.txt")
+	if err != nil {
+		log.Printf("Cannot read robots.txt of %s: %v", shop.Name, err)
+	}
+	if !declared {
+		err = c.Visit(base + "/sitemap.xml")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, errors.Join(append([]error{ErrorEmptySitemap}, e...)...)
+	}
+
+	return urls, nil
+}
+
+// shopSitemap returns the cached sitemap of a shop, fetching it again when
+// it is too old. The cache is not locked while fetching, so a slow shop does
+// not hold up the others.
+func shopSitemap(shop *Shop) ([]string, error) {
+	sitemapsMutex.Lock()
+	cached, ok := sitemaps[shop]
+	sitemapsMutex.Unlock()
+	if ok && time.Since(cached.fetched) < sitemapTTL {
+		return cached.urls, nil
+	}
+
+	urls, err := fetchSitemap(shop)
+	if err != nil {
+		return nil, err
+	}
+
+	sitemapsMutex.Lock()
+	sitemaps[shop] = &sitemapCache{urls: urls, fetched: time.Now()}
+	sitemapsMutex.Unlock()
+
+	return urls, nil
+}
+
+// Search looks for products in every registered shop, by matching the query
+// against page addresses in the sitemaps. The best matches are fetched, and
+// at most limit of them, which turn out to be products, are returned.
+func Search(query string, limit int) ([]ProductInfo, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil, ErrorEmptyQuery
+	}
+
+	type candidate struct {
+		url   string
+		words int
+	}
+	candidates := []candidate{}
+	e := []error{}
+
+	for _, shop := range shops {
+		urls, err := shopSitemap(shop)
+		if err != nil {
+			e = append(e, errors.Join(errors.New("when reading sitemap of "+shop.Name), err))
+			continue
+		}
+
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				continue
+			}
+			words := searchTokens(parsed.Path)
+
+			score := 0
+			for _, token := range tokens {
+				for _, word := range words {
+					if strings.HasPrefix(word, token) {
+						score++
+						break
+					}
+				}
+			}
+			// every word of the query has to be found
+			if score == len(tokens) {
+				candidates = append(candidates, candidate{url: u, words: len(words)})
+			}
+		}
+	}
+
+	// the shorter the address, the fewer words are not in the query
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].words < candidates[j].words
+	})
+	if len(candidates) > searchCandidates {
+		candidates = candidates[:searchCandidates]
+	}
+
+	// results are tracked by their url, so it is normalized like any other
+	results := []ProductInfo{}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if len(results) >= limit {
+			break
+		}
+		url, err := NormalizeUrl(c.url)
+		if err != nil || seen[url] {
+			continue
+		}
+		seen[url] = true
+		info, err := FetchProduct(url)
+		if err != nil {
+			continue
+		}
+		results = append(results, info)
+	}
+
+	if len(results) == 0 && len(e) > 0 {
+		return nil, errors.Join(e...)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,216 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// serveShop serves the recorded pages in dir as the only registered shop.
+// Pages are stored without the .html extension in their address, gzipped
+// sitemaps are stored uncompressed, and {{host}} is replaced with the
+// address of the server. Returns the address and a counter of requests.
+func serveShop(t *testing.T, dir string) (string, *int) {
+	t.Helper()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		name := strings.TrimSuffix(r.URL.Path, ".gz")
+		if path.Ext(name) == "" {
+			name += ".html"
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data = bytes.ReplaceAll(data, []byte("{{host}}"), []byte(r.Host))
+
+		if strings.HasSuffix(r.URL.Path, ".gz") {
+			w.Header().Set("Content-Type", "application/x-gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write(data)
+			gz.Close()
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	oldShops, oldScheme := shops, shopScheme
+	// colly matches the port, urls are looked up without it
+	shops = []*Shop{{Name: "Test", Domains: []string{host, strings.Split(host, ":")[0]}}}
+	shopScheme = "http"
+	sitemaps = map[*Shop]*sitemapCache{}
+	t.Cleanup(func() {
+		shops, shopScheme = oldShops, oldScheme
+		sitemaps = map[*Shop]*sitemapCache{}
+	})
+
+	return server.URL, &requests
+}
+
+func TestFetchSitemap(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		want []string
+	}{
+		{
+			name: "robots.txt with index and gzipped sitemap",
+			dir:  "testdata/shop",
+			want: []string{
+				"/akcijas/paracetamols",
+				"/par-mums",
+				"/produkti/ibuprofens-400mg-tabletes-n10",
+				"/produkti/paracetamols-500mg-tabletes-n20",
+				"/produkti/vitamins-c-1000mg-n30?utm_source=sitemap",
+			},
+		},
+		{
+			name: "no robots.txt",
+			dir:  "testdata/shop_without_robots",
+			want: []string{"/produkti/paracetamols-500mg-tabletes-n20"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, _ := serveShop(t, test.dir)
+
+			urls, err := fetchSitemap(shops[0])
+			if err != nil {
+				t.Fatalf("fetchSitemap: %v", err)
+			}
+			sort.Strings(urls)
+
+			want := []string{}
+			for _, p := range test.want {
+				want = append(want, base+p)
+			}
+			if !slices.Equal(urls, want) {
+				t.Errorf("got %q, want %q", urls, want)
+			}
+		})
+	}
+}
+
+func TestFetchSitemapEmpty(t *testing.T) {
+	serveShop(t, t.TempDir())
+
+	_, err := fetchSitemap(shops[0])
+	if err == nil {
+		t.Fatal("expected an error for a shop without a sitemap")
+	}
+}
+
+func TestShopSitemapIsCached(t *testing.T) {
+	_, requests := serveShop(t, "testdata/shop")
+
+	first, err := shopSitemap(shops[0])
+	if err != nil {
+		t.Fatalf("shopSitemap: %v", err)
+	}
+	n := *requests
+
+	second, err := shopSitemap(shops[0])
+	if err != nil {
+		t.Fatalf("shopSitemap: %v", err)
+	}
+	if *requests != n {
+		t.Errorf("cached sitemap was fetched again: %d requests instead of %d", *requests, n)
+	}
+	if !slices.Equal(first, second) {
+		t.Errorf("cached sitemap differs: %q and %q", first, second)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"paracetamols", []string{"Paracetamols 500mg tabletes N20"}},
+		{"ibuprofēns 400", []string{"Ibuprofēns 400mg tabletes N10"}},
+		{"tabletes", []string{"Paracetamols 500mg tabletes N20", "Ibuprofēns 400mg tabletes N10"}},
+		{"aspirīns", []string{}},
+	}
+
+	serveShop(t, "testdata/shop")
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			results, err := Search(test.query, 5)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+
+			names := []string{}
+			for _, info := range results {
+				names = append(names, info.Name)
+			}
+			sort.Strings(names)
+			want := slices.Clone(test.want)
+			sort.Strings(want)
+			if !slices.Equal(names, want) {
+				t.Errorf("got %q, want %q", names, want)
+			}
+		})
+	}
+}
+
+func TestSearchResult(t *testing.T) {
+	base, _ := serveShop(t, "testdata/shop")
+
+	results, err := Search("paracetamols", 1)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+
+	a := results[0].Availability
+	if !a.Found || a.Sku != "1001234" || a.Gtin != "4751234567890" {
+		t.Errorf("unexpected product: %+v", a)
+	}
+	if a.Url != base+"/produkti/paracetamols-500mg-tabletes-n20" {
+		t.Errorf("got url %s", a.Url)
+	}
+	if a.Price.String() != "2.49 EUR" {
+		t.Errorf("got price %s", a.Price)
+	}
+}
+
+// Results are tracked by url, so they are normalized like urls sent by users.
+func TestSearchNormalizesUrl(t *testing.T) {
+	base, _ := serveShop(t, "testdata/shop")
+
+	results, err := Search("vitamins", 1)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if url := results[0].Availability.Url; url != base+"/produkti/vitamins-c-1000mg-n30" {
+		t.Errorf("got url %s", url)
+	}
+}
+
+func TestSearchEmptyQuery(t *testing.T) {
+	_, err := Search(" - ", 5)
+	if !errors.Is(err, ErrorEmptyQuery) {
+		t.Errorf("got %v, want %v", err, ErrorEmptyQuery)
+	}
+}
@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Shop is a web shop the scraper knows how to read.
+type Shop struct {
+	Name string
+	// The first domain is the canonical one, others are rewritten to it.
+	Domains []string
+}
+
+var shops = []*Shop{
+	{Name: "Apotheka", Domains: []string{"www.apotheka.lv", "apotheka.lv"}},
+}
+
+// Scheme shops are visited with, when not following a given url. Normalized
+// urls use it too.
+var shopScheme = "https"
+
+// Query parameters, that only track where the visitor came from.
+var trackingParams = []string{"fbclid", "gclid", "yclid", "mc_cid", "mc_eid"}
+
+func allowedDomains() []string {
+	domains := []string{}
+	for _, shop := range shops {
+		domains = append(domains, shop.Domains...)
+	}
+	return domains
+}
+
+// ShopFor returns the shop serving the given domain, or nil.
+func ShopFor(domain string) *Shop {
+	domain = strings.ToLower(domain)
+	for _, shop := range shops {
+		for _, d := range shop.Domains {
+			if d == domain {
+				return shop
+			}
+		}
+	}
+	return nil
+}
+
+// shopName returns the name of the shop serving url, or an empty string.
+func shopName(url string) string {
+	if shop := ShopFor(domainOf(url)); shop != nil {
+		return shop.Name
+	}
+	return ""
+}
+
+// NormalizeUrl brings a product url typed or shared by a user into the form
+// it is tracked in: https, canonical shop domain, no fragment and no tracking
+// parameters.
+func NormalizeUrl(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.Join(ErrorInvalidUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", ErrorInvalidUrl
+	}
+
+	shop := ShopFor(u.Hostname())
+	if shop == nil {
+		return "", errors.Join(ErrorUnsupportedShop, errors.New("domain "+u.Hostname()))
+	}
+
+	u.Scheme = shopScheme
+	u.Host = shop.Domains[0]
+	u.Fragment = ""
+	u.User = nil
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+		}
+	}
+	for _, key := range trackingParams {
+		query.Del(key)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// ValidateProduct normalizes the url and fetches it once, to make sure there
+// is a product to track.
+func ValidateProduct(raw string) (string, ProductInfo, error) {
+	u, err := NormalizeUrl(raw)
+	if err != nil {
+		return "", ProductInfo{}, err
+	}
+
+	info, err := FetchProduct(u)
+	if err != nil {
+		return u, info, err
+	}
+
+	return u, info, nil
+}
@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"aphoteka_scraper/manifest"
+	"encoding/json"
+	"strings"
+)
+
+// offer is a single offer of a product. Shops, that tell stock per pharmacy,
+// list an offer for every pickup location next to the one of the web shop.
+type offer struct {
+	Availability       string          `json:"availability"`
+	Price              identifier      `json:"price"`
+	PriceCurrency      string          `json:"priceCurrency"`
+	PriceValidUntil    string          `json:"priceValidUntil"`
+	PriceSpecification json.RawMessage `json:"priceSpecification"`
+	AvailableAtOrFrom  json.RawMessage `json:"availableAtOrFrom"`
+}
+
+type place struct {
+	Name    string          `json:"name"`
+	Address json.RawMessage `json:"address"`
+}
+
+// parseOffers accepts both a single offer and a list.
+func parseOffers(raw json.RawMessage) []offer {
+	if len(raw) == 0 {
+		return nil
+	}
+	var list []offer
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var single offer
+	if json.Unmarshal(raw, &single) == nil {
+		return []offer{single}
+	}
+	return nil
+}
+
+// splitOffers separates the offer of the web shop from the offers of
+// pharmacies. Without a web shop offer, the first one is used.
+func splitOffers(offers []offer) (offer, []manifest.Store) {
+	var main offer
+	found := false
+	stores := []manifest.Store{}
+
+	for _, o := range offers {
+		store, ok := parseStore(o)
+		if ok {
+			stores = append(stores, store)
+			continue
+		}
+		if !found {
+			main, found = o, true
+		}
+	}
+	if !found && len(offers) > 0 {
+		main = offers[0]
+	}
+	manifest.SortStores(stores)
+
+	return main, stores
+}
+
+func parseStore(o offer) (manifest.Store, bool) {
+	if len(o.AvailableAtOrFrom) == 0 || string(o.AvailableAtOrFrom) == "null" {
+		return manifest.Store{}, false
+	}
+
+	var p place
+	err := json.Unmarshal(o.AvailableAtOrFrom, &p)
+	if err != nil || p.Name == "" {
+		return manifest.Store{}, false
+	}
+
+	store := manifest.Store{Name: strings.TrimSpace(p.Name), Stock: manifest.ParseStock(o.Availability)}
+
+	// the address is either a PostalAddress or just text
+	var address struct {
+		StreetAddress   string `json:"streetAddress"`
+		AddressLocality string `json:"addressLocality"`
+	}
+	var text string
+	if json.Unmarshal(p.Address, &address) == nil {
+		store.Address = strings.TrimSpace(address.StreetAddress)
+		store.City = strings.TrimSpace(address.AddressLocality)
+	} else if json.Unmarshal(p.Address, &text) == nil {
+		store.Address = strings.TrimSpace(text)
+	}
+
+	return store, true
+}
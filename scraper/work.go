@@ -5,32 +5,92 @@ import (
 	"aphoteka_scraper/permanence"
 	"errors"
 	"log"
+	"slices"
+	"time"
 )
 
-func FetchAndCompare(urls map[string]string) (newManifest manifest.Manifest, needsUpdate bool, e error) {
+// Settings control how the tracked products are checked.
+type Settings struct {
+	Interval      time.Duration
+	Confirmations map[string]ConfirmRule
+	// Paused products are not fetched and keep their last known state.
+	Paused map[string]struct{}
+	// Packs override pack sizes read from product names.
+	Packs map[string]manifest.Pack
+	// If set, only these products are fetched, the others keep their last
+	// known state.
+	Only map[string]struct{}
+}
+
+func FetchAndCompare(urls map[string]string, settings Settings) (newManifest manifest.Manifest, needsUpdate bool, report Report, e error) {
 	ers := []error{}
 
+	active := map[string]string{}
+	for name, url := range urls {
+		if _, paused := settings.Paused[name]; paused {
+			continue
+		}
+		if _, ok := settings.Only[name]; settings.Only != nil && !ok {
+			continue
+		}
+		active[name] = url
+	}
+
+	// load previous manifest from disk
+	prev_manifest, err := permanence.LoadManifest()
+	if err != nil {
+		log.Printf("Cannot access previous manifest: %v", err)
+
+		ers = append(ers, err)
+	}
+
 	// fetch the date, generate a new manifest
-	m, err := FetchData(urls)
+	m, report, err := FetchData(active, prev_manifest, settings.Interval)
+	report.Previous = prev_manifest
 	if err != nil {
 		e = err
 		return
 	}
 	newManifest = m
 
-	// load previous manifest from disk
-	prev_manifest, err := permanence.LoadManifest()
-	if err != nil {
-		log.Printf("Cannot access previous manifest: %v", err)
+	fetched := []string{}
+	for name, url := range active {
+		if !slices.Contains(report.Skipped, domainOf(url)) {
+			fetched = append(fetched, name)
+		}
+	}
+
+	// paused and not requested products, and products on domains that are backing off keep
+	// their last known state
+	for name := range urls {
+		if _, ok := m[name]; ok {
+			continue
+		}
+		if prev, ok := prev_manifest[name]; ok {
+			m[name] = prev
+		}
+	}
+
+	for name, pack := range settings.Packs {
+		if a, ok := m[name]; ok && a.Found {
+			a.Pack = pack
+			m[name] = a
+		}
+	}
 
+	// changes that need confirmation are held back until seen often enough
+	report.Unconfirmed, err = confirmChanges(prev_manifest, m, fetched, settings.Confirmations)
+	if err != nil {
 		ers = append(ers, err)
+		log.Printf("Cannot save changes waiting for confirmation: %v", err)
 	}
 
 	// check whether manifests match
 	needsUpdate = !manifest.AreEqual(prev_manifest, m)
 
-	// save new manifest to disk
-	if needsUpdate {
+	// save new manifest to disk, also when only identifiers were filled in,
+	// which nobody is notified about
+	if needsUpdate || !manifest.AreIdentical(prev_manifest, m) {
 		err = permanence.SaveManifest(m)
 		if err != nil {
 			// permanence.Logger.AddError(err)
@@ -39,6 +99,14 @@ func FetchAndCompare(urls map[string]string) (newManifest manifest.Manifest, nee
 		}
 	}
 
+	if needsUpdate {
+		err = permanence.AppendHistory(time.Now(), m, manifest.Changed(prev_manifest, m))
+		if err != nil {
+			ers = append(ers, err)
+			log.Printf("Cannot record price history: %v", err)
+		}
+	}
+
 	e = errors.Join(ers...)
 
 	return
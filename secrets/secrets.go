@@ -1,27 +1,136 @@
 package secrets
 
 import (
-	_ "embed"
+	"bufio"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
 	"strings"
 )
 
-//go:embed token.secret
-var Token string
+var ErrorMissingSetting = errors.New("required setting is missing")
+
+// Secrets files, that were present at build time, are the last fallback.
+// Any of them may be missing: placeholder.secret is tracked, so that the
+// pattern matches even on a clean checkout.
+//
+//go:embed *.secret
+var embedded embed.FS
 
-//go:embed root_user.secret
+var Token string
 var RootUser string
+var ChannelIds []string
 
-//go:embed chats.secret
-var channelsRaw string
+var tokenFlag = flag.String("token", "", "telegram bot `token`")
+var rootUserFlag = flag.String("root-user", "", "telegram `username` of the root user")
+var channelsFlag = flag.String("channels", "", "comma separated channel `ids`")
+var secretsFileFlag = flag.String("secrets", "", "read settings from a `file` of KEY=value lines")
 
-var ChannelIds []string
+// setting is one value, looked up in order: flag, environment variable,
+// secrets file, embedded file.
+type setting struct {
+	flag         string
+	env          string
+	embeddedFile string
+	flagValue    string
+	embedded     string
+	value        *string
+	required     bool
+}
 
-func init() {
-	parts := strings.Split(channelsRaw, "\n")
-	for _, part := range parts {
-		ChannelIds = append(ChannelIds, strings.TrimSpace(part))
+// Load looks up the settings. It has to be called after flag.Parse.
+// Flags win over environment variables, which win over the secrets file,
+// which wins over files embedded at build time.
+func Load() error {
+	filename := *secretsFileFlag
+	if filename == "" {
+		filename = os.Getenv("APHOTEKA_SECRETS")
+	}
+	file := map[string]string{}
+	if filename != "" {
+		var err error
+		file, err = readSecretsFile(filename)
+		if err != nil {
+			return errors.Join(fmt.Errorf("cannot read secrets file %s", filename), err)
+		}
+	}
+
+	var channels string
+	settings := []setting{
+		{"token", "APHOTEKA_TOKEN", "secrets/token.secret", *tokenFlag, embeddedFile("token.secret"), &Token, true},
+		{"root-user", "APHOTEKA_ROOT_USER", "secrets/root_user.secret", *rootUserFlag, embeddedFile("root_user.secret"), &RootUser, true},
+		{"channels", "APHOTEKA_CHANNELS", "secrets/chats.secret", *channelsFlag, embeddedFile("chats.secret"), &channels, false},
+	}
+
+	e := []error{}
+	for _, s := range settings {
+		for _, v := range []string{s.flagValue, os.Getenv(s.env), file[s.env], s.embedded} {
+			if v = strings.TrimSpace(v); v != "" {
+				*s.value = v
+				break
+			}
+		}
+		if *s.value == "" && s.required {
+			e = append(e, fmt.Errorf("%s is not set: use -%s, %s, %s in the secrets file or %s",
+				s.flag, s.flag, s.env, s.env, s.embeddedFile))
+		}
+	}
+	if len(e) > 0 {
+		return errors.Join(append([]error{ErrorMissingSetting}, e...)...)
 	}
 
 	RootUser = "@" + strings.TrimPrefix(RootUser, "@")
+	ChannelIds = splitList(channels)
+
+	return nil
+}
+
+// embeddedFile returns the content of an embedded secrets file, or nothing
+// when the file was missing at build time.
+func embeddedFile(name string) string {
+	data, err := embedded.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// splitList splits a list separated by commas or newlines, skipping blank
+// items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readSecretsFile reads KEY=value lines. Blank lines and lines starting
+// with # are skipped.
+func readSecretsFile(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d is not KEY=value", n)
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
 
+	return values, scanner.Err()
 }
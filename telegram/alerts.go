@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func reachedTarget(a manifest.Availability, target uint) bool {
+	return a.Stock.Buyable() && a.Price.Cents <= int64(target)
+}
+
+func reachedUnitTarget(a manifest.Availability, target float64) bool {
+	price, ok := a.UnitPrice()
+	return ok && a.Stock.Buyable() && price <= target
+}
+
+// targetAlerts returns messages about products, that have just dropped to
+// or below their target price.
+func targetAlerts(prev, next manifest.Manifest) []string {
+	alerts := []string{}
+	for name, target := range config.Targets {
+		a, ok := next[name]
+		if !ok || !reachedTarget(a, target) {
+			continue
+		}
+		if old, ok := prev[name]; ok && reachedTarget(old, target) {
+			continue
+		}
+
+		alerts = append(alerts, fmt.Sprintf("🎯 %s costs %s, target was %s.\n%s",
+			name, a.Price.Format(config.Locale),
+			manifest.FromCents(int64(target), a.Price.Currency).Format(config.Locale), a.Url))
+	}
+	for name, target := range config.UnitTargets {
+		a, ok := next[name]
+		if !ok || !reachedUnitTarget(a, target) {
+			continue
+		}
+		if old, ok := prev[name]; ok && reachedUnitTarget(old, target) {
+			continue
+		}
+
+		alerts = append(alerts, fmt.Sprintf("🎯 %s costs %s, target was %s.\n%s",
+			name, a.UnitPriceNote(config.Locale),
+			manifest.FormatUnitPrice(target, a.Price.Currency, a.Pack.Unit, config.Locale), a.Url))
+	}
+	sort.Strings(alerts)
+
+	return alerts
+}
+
+// stockAlerts returns messages about products, that have just entered one
+// of the stock states chosen for them.
+func stockAlerts(prev, next manifest.Manifest) []string {
+	alerts := []string{}
+	for name, states := range config.StockAlerts {
+		a, ok := next[name]
+		if !ok || !a.Found || !slices.Contains(states, a.Stock) {
+			continue
+		}
+		if old, ok := prev[name]; ok && old.Found && old.Stock == a.Stock {
+			continue
+		}
+
+		alerts = append(alerts, fmt.Sprintf("%s %s is %s.\n%s", a.Stock.Icon(), name, a.Stock.Wording(), a.Url))
+	}
+	sort.Strings(alerts)
+
+	return alerts
+}
+
+// priceAlerts returns messages about products, that went on sale, whose
+// sale is over, or whose regular price has changed.
+func priceAlerts(prev, next manifest.Manifest) []string {
+	alerts := []string{}
+	for _, name := range manifest.Changed(prev, next) {
+		old, ok := prev[name]
+		if !ok {
+			continue
+		}
+		a, ok := next[name]
+		if !ok {
+			continue
+		}
+		change := manifest.PriceChange(old, a, config.Locale)
+		if change == "" {
+			continue
+		}
+
+		icon := "💶"
+		if a.OnSale() {
+			icon = "🏷"
+		}
+		alerts = append(alerts, fmt.Sprintf("%s %s: %s\n%s", icon, name, change, a.Url))
+	}
+
+	return alerts
+}
+
+// sendAlerts sends every alert to all notify channels as a separate message.
+func sendAlerts(ctx context.Context, b *bot.Bot, alerts []string) error {
+	error_slice := []error{}
+	for _, alert := range alerts {
+		for _, channel := range config.NotifyChannels {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: channel,
+				Text:   alert,
+				LinkPreviewOptions: &models.LinkPreviewOptions{
+					IsDisabled: bot.True(),
+				},
+			})
+			if err != nil {
+				error_slice = append(error_slice, err)
+			}
+		}
+	}
+
+	return errors.Join(error_slice...)
+}
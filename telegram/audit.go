@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// How many entries /audit lists without an argument.
+const defaultAuditEntries = 10
+
+// At most this many changes of an entry are listed by /audit.
+const maxAuditChanges = 5
+
+// auditText describes audit entries, newest first, one part per entry.
+func auditText(entries []permanence.AuditEntry) []string {
+	parts := []string{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		lines := []string{fmt.Sprintf("#%d %s %s: %s",
+			entry.Id, entry.Time.Format("02.01 15:04"), entry.Actor, entry.Action)}
+		for j, change := range entry.Changes {
+			if j == maxAuditChanges {
+				lines = append(lines, fmt.Sprintf("  ... and %d more", len(entry.Changes)-maxAuditChanges))
+				break
+			}
+			lines = append(lines, "  "+describeChange(change))
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return parts
+}
+
+func handleAudit(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	n := defaultAuditEntries
+	s, ok := strings.CutPrefix(update.Message.Text, "/audit ")
+	if ok {
+		var err error
+		n, err = strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Syntax: /audit [number of entries]",
+			})
+			handleSendError(ctx, b, err)
+			return
+		}
+	}
+
+	entries, err := permanence.LoadAudit()
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Cannot read the audit log: %v", err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	parts := []string{"No changes are recorded yet."}
+	if len(entries) > 0 {
+		parts = auditText(entries[max(0, len(entries)-n):])
+	}
+	for _, msg := range manifest.Chunk(parts, "\n", manifest.MessageLimit) {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   msg,
+			LinkPreviewOptions: &models.LinkPreviewOptions{
+				IsDisabled: bot.True(),
+			},
+		})
+		handleSendError(ctx, b, err)
+	}
+}
+
+// revertChanges puts the settings of an audit entry back to what they were
+// before it. Settings changed again since are left alone and returned.
+func revertChanges(entry permanence.AuditEntry) (serverConfig, []permanence.AuditChange, error) {
+	flat := flattenConfig(config)
+	reverted := maps.Clone(flat)
+	skipped := []permanence.AuditChange{}
+
+	for _, change := range entry.Changes {
+		if flat[change.Key] != change.After {
+			skipped = append(skipped, change)
+			continue
+		}
+		if change.Before == "" {
+			delete(reverted, change.Key)
+		} else {
+			reverted[change.Key] = change.Before
+		}
+	}
+
+	c, err := unflattenConfig(reverted, config)
+	return c, skipped, err
+}
+
+func handleUndo(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/undo ")
+	id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if !ok || err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /undo <id from /audit>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	entries, err := permanence.LoadAudit()
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Cannot read the audit log: %v", err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	// ids are looked up, the log may have gaps
+	i := slices.IndexFunc(entries, func(entry permanence.AuditEntry) bool { return entry.Id == id })
+	if i < 0 {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("No such change #%d, see /audit.", id),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	c, skipped, err := revertChanges(entries[i])
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Change #%d cannot be undone: %v", id, err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	diff := diffConfigs(config, c)
+	prev := config
+	config = c
+	err = saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	// the update loop only follows the active flag and the interval, when
+	// it is set up again
+	if config.Active && (!prev.Active || prev.Interval != config.Interval) {
+		setupLoop(ctx, b)
+	} else if !config.Active && prev.Active {
+		loopStopHandle <- unit
+	}
+
+	lines := []string{fmt.Sprintf("Change #%d is undone.", id)}
+	if len(diff) == 0 {
+		lines[0] = fmt.Sprintf("Nothing of change #%d is left to undo.", id)
+	}
+	lines = append(lines, diff...)
+	if len(skipped) > 0 {
+		lines = append(lines, "Left alone, since they were changed again later:")
+		for _, change := range skipped {
+			lines = append(lines, change.Key)
+		}
+	}
+
+	for _, msg := range manifest.Chunk(lines, "\n", manifest.MessageLimit) {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   msg,
+			LinkPreviewOptions: &models.LinkPreviewOptions{
+				IsDisabled: bot.True(),
+			},
+		})
+		handleSendError(ctx, b, err)
+	}
+}
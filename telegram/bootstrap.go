@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"aphoteka_scraper/secrets"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// bootstrapConfig builds the config described by the bootstrap file, in the
+// format of /export_config, with the channels from the secrets added as
+// notification channels. Settings missing from the file, or all of them
+// without a file, keep their defaults.
+func bootstrapConfig(filename string) (serverConfig, error) {
+	c := newServerConfig()
+	c.DashboardMessages = maps.Clone(config.DashboardMessages)
+
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return serverConfig{}, err
+		}
+		defer f.Close()
+
+		c, err = decodeBootstrapConfig(f, config)
+		if err != nil {
+			return serverConfig{}, errors.Join(fmt.Errorf("when reading %s", filename), err)
+		}
+	}
+
+	for _, channel := range secrets.ChannelIds {
+		if !slices.Contains(c.NotifyChannels, channel) {
+			c.NotifyChannels = append(c.NotifyChannels, channel)
+		}
+	}
+
+	return c, nil
+}
+
+// applyBootstrap replaces the config with the bootstrap one. Reset tells,
+// that there was a saved config already. Returns what changed.
+func applyBootstrap(filename string, reset bool) ([]string, error) {
+	c, err := bootstrapConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := diffConfigs(config, c)
+	config = c
+
+	action := "first start"
+	if reset {
+		action = "-reset-from-bootstrap"
+	}
+	return diff, saveServerConfig("bootstrap", action)
+}
+
+func bootstrapReport(diff []string) string {
+	if len(diff) == 0 {
+		return "Config is reset from the bootstrap file, nothing has changed."
+	}
+	return fmt.Sprintf("Config is reset from the bootstrap file, %d changes, see /audit:\n%s",
+		len(diff), strings.Join(diff[:min(len(diff), maxDiffLines)], "\n"))
+}
@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// compareText lists price and stock of a product at every shop selling it.
+func compareText(m manifest.Manifest, name string) string {
+	group := m.Group(name)
+	if len(group) == 0 {
+		return fmt.Sprintf("There is no data about %q yet.", name)
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s\n", name)
+	if m[name].Gtin == "" {
+		fmt.Fprintf(&s, "The shop does not tell the GTIN of this product, so it cannot be matched across shops.\n")
+	}
+	labels := m.SourceLabels(group)
+	for _, other := range group {
+		a := m[other]
+		line := "not found"
+		if a.Found {
+			line = fmt.Sprintf("%s %s @ %s", a.Stock.Icon(), a.Stock.Wording(), a.Price.Format(config.Locale))
+			if note := a.UnitPriceNote(config.Locale); note != "" {
+				line += fmt.Sprintf(" (%s, pack of %s)", note, a.Pack)
+			}
+		}
+		fmt.Fprintf(&s, "\n%s: %s\n%s\n", labels[other], line, a.Url)
+	}
+
+	return strings.TrimSpace(s.String())
+}
+
+// cheapestAlerts returns messages about products sold by several shops,
+// whose cheapest source in stock has changed.
+func cheapestAlerts(prev, next manifest.Manifest) []string {
+	alerts := []string{}
+	for _, group := range next.Groups() {
+		if len(group) < 2 {
+			continue
+		}
+		was, ok := prev.Cheapest(group)
+		if !ok {
+			continue
+		}
+		now, ok := next.Cheapest(group)
+		if !ok || now == was {
+			continue
+		}
+
+		a := next[now]
+		alerts = append(alerts, fmt.Sprintf("🛒 %s is now cheapest at %s: %s, before it was %s.\n%s",
+			group[0], next.SourceLabels(group)[now], a.Price.Format(config.Locale),
+			prev.SourceLabels(group)[was], a.Url))
+	}
+	sort.Strings(alerts)
+
+	return alerts
+}
+
+func handleCompare(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	name, ok := strings.CutPrefix(update.Message.Text, "/compare ")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /compare <name of product>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	lastManifest, err := permanence.LoadManifest()
+	if err != nil {
+		handleError(ctx, b, errors.Join(ErrorCannotLoadManifest, err))
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   compareText(lastManifest, name),
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+	handleSendError(ctx, b, err)
+}
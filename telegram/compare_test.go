@@ -0,0 +1,33 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"strings"
+	"testing"
+)
+
+// With one shop, every source is the same shop, so products are told apart
+// by name.
+func TestCheapestAlertsNameProductsOfOneShop(t *testing.T) {
+	offer := func(cents int64) manifest.Availability {
+		return manifest.Availability{
+			Price: manifest.Money{Cents: cents, Currency: "EUR"},
+			Found: true,
+			Stock: manifest.StockInStock,
+			Gtin:  "4751234567890",
+			Shop:  "Apotheka",
+		}
+	}
+	prev := manifest.Manifest{"Paracetamols N20": offer(435), "Paracetamols 500mg": offer(499)}
+	next := manifest.Manifest{"Paracetamols N20": offer(535), "Paracetamols 500mg": offer(499)}
+
+	alerts := cheapestAlerts(prev, next)
+	if len(alerts) != 1 {
+		t.Fatalf("got alerts %q, want one", alerts)
+	}
+	if strings.Contains(alerts[0], "Apotheka") ||
+		!strings.Contains(alerts[0], "cheapest at Paracetamols 500mg") ||
+		!strings.Contains(alerts[0], "before it was Paracetamols N20") {
+		t.Errorf("got %q", alerts[0])
+	}
+}
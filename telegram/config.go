@@ -1,90 +1,155 @@
 package telegram
 
 import (
+	"aphoteka_scraper/manifest"
 	"aphoteka_scraper/permanence"
+	"aphoteka_scraper/scraper"
 	"aphoteka_scraper/secrets"
-	"bytes"
-	"encoding/gob"
-	"os"
-	"path"
+	"errors"
+	"strings"
 	"time"
 )
 
 type serverConfig struct {
-	Whitelist       map[string]struct{}
-	NotifyChannels  []string
-	ServiceChannels []string
-	Products        map[string]string
-	Active          bool
-	Interval        time.Duration
+	Whitelist         map[string]struct{}
+	NotifyChannels    []string
+	ServiceChannels   []string
+	Products          map[string]string
+	Active            bool
+	Interval          time.Duration
+	Confirmations     map[string]scraper.ConfirmRule
+	Format            manifest.Format
+	Dashboard         bool
+	DashboardMessages map[string]int
+	Paused            map[string]struct{}
+	Targets           map[string]uint
+	Watches           map[string]string
+	Packs             map[string]manifest.Pack
+	UnitTargets       map[string]float64
+	Locale            manifest.Locale
+	StockAlerts       map[string][]manifest.Stock
+	CityAlerts        map[string]string
 }
 
 var config serverConfig
+
+// savedConfig is the flattened config as it was last saved, to tell what a
+// save changes.
+var savedConfig map[string]string
+
+// Every version of the config so far only added fields.
+var configSchema = permanence.Schema{
+	Name: "config.gob",
+	Migrations: []permanence.Migration{
+		permanence.AsIs,
+	},
+}
+
 var unit = struct{}{}
 
 func newServerConfig() serverConfig {
 	return serverConfig{
-		Whitelist:       map[string]struct{}{secrets.RootUser: unit},
-		NotifyChannels:  []string{},
-		ServiceChannels: []string{},
-		Products:        map[string]string{},
-		Active:          true,
-		Interval:        1 * time.Hour,
+		Whitelist:         map[string]struct{}{secrets.RootUser: unit},
+		NotifyChannels:    []string{},
+		ServiceChannels:   []string{},
+		Products:          map[string]string{},
+		Active:            true,
+		Interval:          1 * time.Hour,
+		Confirmations:     map[string]scraper.ConfirmRule{},
+		Format:            manifest.FormatPlain,
+		Dashboard:         false,
+		DashboardMessages: map[string]int{},
+		Paused:            map[string]struct{}{},
+		Targets:           map[string]uint{},
+		Watches:           map[string]string{},
+		Packs:             map[string]manifest.Pack{},
+		UnitTargets:       map[string]float64{},
+		Locale:            manifest.LocaleEnglish,
+		StockAlerts:       map[string][]manifest.Stock{},
+		CityAlerts:        map[string]string{},
 	}
 }
 
-func loadServerConfig() error {
-	p, err := permanence.GetUserDir()
+// loadServerConfig loads the saved config. Returns false, if there is none
+// yet, and the default config is used.
+func loadServerConfig() (bool, error) {
+	var c serverConfig
+	err := configSchema.Load(&c, func() {
+		c = newServerConfig()
+	})
 	if err != nil {
-		return err
-	}
-	p = path.Join(p, "config.gob")
-
-	data, err := os.ReadFile(p)
-	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, permanence.ErrorNotFound) {
 			config = newServerConfig()
-			return nil
+			savedConfig = flattenConfig(config)
+			return false, nil
 		} else {
-			return err
+			return false, err
 		}
 	}
 
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-
-	c := newServerConfig()
-	err = dec.Decode(&c)
-	if err != nil {
-		return err
-	}
-
 	c.Whitelist[secrets.RootUser] = unit
 
 	config = c
+	savedConfig = flattenConfig(config)
 
-	return nil
+	return true, nil
 }
 
-func saveServerConfig() error {
-	p, err := permanence.GetUserDir()
+// saveServerConfig saves the config and records in the audit log, who
+// changed what. Actor is a username, or what else made the change, action
+// is usually the command. When only the audit entry cannot be written, the
+// error wraps ErrorCannotAudit.
+func saveServerConfig(actor, action string) error {
+	err := configSchema.Save(&config)
 	if err != nil {
 		return err
 	}
-	p = path.Join(p, "config.gob")
 
-	buf := bytes.Buffer{}
-	enc := gob.NewEncoder(&buf)
+	flat := flattenConfig(config)
+	changes := configChanges(savedConfig, flat)
+	savedConfig = flat
+	if len(changes) == 0 {
+		return nil
+	}
 
-	err = enc.Encode(&config)
+	_, err = permanence.AppendAudit(permanence.AuditEntry{
+		Time:    time.Now(),
+		Actor:   actor,
+		Action:  action,
+		Changes: changes,
+	})
 	if err != nil {
-		return err
+		return errors.Join(ErrorCannotAudit, err)
 	}
+	return nil
+}
 
-	err = os.WriteFile(p, buf.Bytes(), 0666)
-	if err != nil {
-		return err
+// forgetProduct removes a product together with all of its settings.
+func forgetProduct(name string) {
+	delete(config.Products, name)
+	delete(config.Confirmations, name)
+	delete(config.Paused, name)
+	delete(config.Targets, name)
+	delete(config.Packs, name)
+	delete(config.UnitTargets, name)
+	delete(config.StockAlerts, name)
+	delete(config.CityAlerts, name)
+}
+
+// splitProductArgs splits command arguments into a product name, which may
+// contain spaces, and up to maxArgs trailing arguments. The longest tail,
+// that leaves the name of a tracked product, wins. When no product matches,
+// exactly one trailing argument is split off.
+func splitProductArgs(fields []string, maxArgs int) (string, []string) {
+	for n := min(maxArgs, len(fields)-1); n >= 1; n-- {
+		name := strings.Join(fields[:len(fields)-n], " ")
+		if _, ok := config.Products[name]; ok {
+			return name, fields[len(fields)-n:]
+		}
 	}
 
-	return nil
+	if len(fields) < 2 {
+		return strings.Join(fields, " "), nil
+	}
+	return strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1:]
 }
@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/scraper"
+	"aphoteka_scraper/secrets"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// config_v0.gob is a config.gob written before files had a version, and
+// before locales, stock alerts and city alerts existed.
+func TestDecodeConfigV0(t *testing.T) {
+	raw, err := os.ReadFile("../permanence/testdata/config_v0.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newServerConfig()
+	err = configSchema.Decode(raw, &c)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	// gob adds to the default whitelist, which holds the root user
+	for _, user := range []string{"@root", "@pharmacist", secrets.RootUser} {
+		if _, ok := c.Whitelist[user]; !ok {
+			t.Errorf("%q is missing from whitelist %v", user, c.Whitelist)
+		}
+	}
+	if !slices.Equal(c.NotifyChannels, []string{"-1001234"}) || !slices.Equal(c.ServiceChannels, []string{"-1005678"}) {
+		t.Errorf("got channels %v and %v", c.NotifyChannels, c.ServiceChannels)
+	}
+	if c.Products["Paracetamols"] != "https://www.apotheka.lv/paracetamols-500mg-n20" || len(c.Products) != 1 {
+		t.Errorf("got products %v", c.Products)
+	}
+	if !c.Active || c.Interval != 30*time.Minute || !c.Dashboard {
+		t.Errorf("got active %v, interval %v, dashboard %v", c.Active, c.Interval, c.Dashboard)
+	}
+	if c.Confirmations["Paracetamols"] != (scraper.ConfirmRule{Checks: 2, PriceChange: 5}) {
+		t.Errorf("got confirmations %v", c.Confirmations)
+	}
+	if c.Format != manifest.FormatMarkdown {
+		t.Errorf("got format %q", c.Format)
+	}
+	if c.DashboardMessages["-1001234"] != 42 {
+		t.Errorf("got dashboard messages %v", c.DashboardMessages)
+	}
+	if c.Targets["Paracetamols"] != 399 || c.UnitTargets["Paracetamols"] != 0.2 {
+		t.Errorf("got targets %v and %v", c.Targets, c.UnitTargets)
+	}
+	if c.Watches["Pretsāpju"] != "https://www.apotheka.lv/pretsapju-lidzekli" {
+		t.Errorf("got watches %v", c.Watches)
+	}
+	if c.Packs["Paracetamols"] != (manifest.Pack{Size: 20, Unit: "pcs"}) {
+		t.Errorf("got packs %v", c.Packs)
+	}
+
+	// fields added later keep their defaults
+	if c.Locale != manifest.LocaleEnglish {
+		t.Errorf("got locale %q", c.Locale)
+	}
+	if c.StockAlerts == nil || len(c.StockAlerts) != 0 || c.CityAlerts == nil || len(c.CityAlerts) != 0 {
+		t.Errorf("got stock alerts %v and city alerts %v", c.StockAlerts, c.CityAlerts)
+	}
+}
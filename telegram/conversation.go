@@ -0,0 +1,269 @@
+package telegram
+
+import (
+	"aphoteka_scraper/scraper"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// How long the bot waits for the next answer in a conversation.
+const conversationTimeout = 10 * time.Minute
+
+type conversationStep int
+
+const (
+	stepAskUrl conversationStep = iota
+	stepAskName
+	stepAskConfigFile
+	stepConfirmImport
+)
+
+type conversationKey struct {
+	chat int64
+	user int64
+}
+
+// conversation is an /add_product or /import_config flow in progress.
+type conversation struct {
+	step     conversationStep
+	url      string
+	name     string
+	info     scraper.ProductInfo
+	imported *serverConfig
+	// flattened config the import was previewed against
+	importBase map[string]string
+	expires    time.Time
+}
+
+var conversations = map[conversationKey]*conversation{}
+var conversationsMutex sync.Mutex
+
+func startConversation(key conversationKey, step conversationStep) {
+	conversationsMutex.Lock()
+	defer conversationsMutex.Unlock()
+
+	now := time.Now()
+	for k, c := range conversations {
+		if now.After(c.expires) {
+			delete(conversations, k)
+		}
+	}
+
+	conversations[key] = &conversation{
+		step:    step,
+		expires: now.Add(conversationTimeout),
+	}
+}
+
+// getConversation returns the conversation in progress, if it did not expire
+// yet. Every access extends the timeout.
+func getConversation(key conversationKey) (*conversation, bool) {
+	conversationsMutex.Lock()
+	defer conversationsMutex.Unlock()
+
+	c, ok := conversations[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(c.expires) {
+		delete(conversations, key)
+		return nil, false
+	}
+	c.expires = time.Now().Add(conversationTimeout)
+
+	return c, true
+}
+
+func endConversation(key conversationKey) bool {
+	conversationsMutex.Lock()
+	defer conversationsMutex.Unlock()
+
+	_, ok := conversations[key]
+	delete(conversations, key)
+
+	return ok
+}
+
+func messageConversationKey(update *models.Update) conversationKey {
+	return conversationKey{chat: update.Message.Chat.ID, user: update.Message.From.ID}
+}
+
+func callbackConversationKey(update *models.Update) conversationKey {
+	key := conversationKey{user: update.CallbackQuery.From.ID}
+	if msg := update.CallbackQuery.Message.Message; msg != nil {
+		key.chat = msg.Chat.ID
+	}
+	return key
+}
+
+func confirmationMarkup() *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: "✅ Confirm", CallbackData: "a:ok"},
+			{Text: "Cancel", CallbackData: "a:cancel"},
+		}},
+	}
+}
+
+func describeProduct(info scraper.ProductInfo) string {
+	a := info.Availability
+	if !a.Found {
+		return "no price or stock information"
+	}
+	return fmt.Sprintf("%s %s @ %s", a.Stock.Icon(), a.Stock.Wording(), a.Price.Format(config.Locale))
+}
+
+func askForConfirmation(ctx context.Context, b *bot.Bot, chatID int64, c *conversation) {
+	text := fmt.Sprintf("Found: %s\n%s\n\nIt will be tracked as %q. Send another name or confirm.",
+		c.info.Name, describeProduct(c.info), c.name)
+	if _, exists := config.Products[c.name]; exists {
+		text += fmt.Sprintf("\nProduct %q already exists, its url will be replaced.", c.name)
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: confirmationMarkup(),
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+	handleSendError(ctx, b, err)
+}
+
+// handleConversationMessage moves the conversation of the sender forward.
+// Returns false, if there is no conversation in progress.
+func handleConversationMessage(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	if update.Message.From == nil {
+		return false
+	}
+	c, ok := getConversation(messageConversationKey(update))
+	if !ok {
+		return false
+	}
+
+	text := strings.TrimSpace(update.Message.Text)
+	chatID := update.Message.Chat.ID
+
+	switch c.step {
+	case stepAskUrl:
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Fetching the product...",
+		})
+		handleSendError(ctx, b, err)
+
+		url, info, err := scraper.ValidateProduct(text)
+		if err != nil {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   fmt.Sprintf("This product cannot be tracked: %v\nSend another url or /cancel.", err),
+			})
+			handleSendError(ctx, b, err)
+			return true
+		}
+
+		c.url = url
+		c.info = info
+		c.name = info.Name
+		if c.name == "" {
+			c.name = url
+		}
+		c.step = stepAskName
+		askForConfirmation(ctx, b, chatID, c)
+
+	case stepAskName:
+		if text == "" {
+			return true
+		}
+		c.name = text
+		askForConfirmation(ctx, b, chatID, c)
+
+	case stepAskConfigFile:
+		if update.Message.Document == nil {
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   "Send the config as a file, or /cancel.",
+			})
+			handleSendError(ctx, b, err)
+			return true
+		}
+		previewImport(ctx, b, update, c)
+
+	case stepConfirmImport:
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Confirm or cancel the import above first.",
+		})
+		handleSendError(ctx, b, err)
+	}
+
+	return true
+}
+
+func handleConversationCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkCallbackPermission(ctx, b, update) {
+		return
+	}
+
+	key := callbackConversationKey(update)
+	c, ok := getConversation(key)
+	if !ok || c.step != stepAskName {
+		editCallbackMessage(ctx, b, update, "This conversation has expired. Start again with /add_product.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	if update.CallbackQuery.Data != "a:ok" {
+		endConversation(key)
+		editCallbackMessage(ctx, b, update, "Adding a product is cancelled.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	endConversation(key)
+	config.Products[c.name] = c.url
+	err := saveServerConfig(callbackActor(update), "/add_product "+c.name)
+	handleSaveError(ctx, b, err)
+
+	editCallbackMessage(ctx, b, update, fmt.Sprintf("Product %q added with url %q.", c.name, c.url), nil)
+	answerCallback(ctx, b, update, "")
+}
+
+func handleCancel(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	text := "There is nothing to cancel."
+	if endConversation(messageConversationKey(update)) {
+		text = "Cancelled."
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
+
+// handleDefault receives every update, that no other handler matched.
+func handleDefault(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	if (update.Message.Text != "" || update.Message.Document != nil) && handleConversationMessage(ctx, b, update) {
+		return
+	}
+	if update.Message.Document != nil && strings.HasPrefix(update.Message.Caption, "/import_config") {
+		handleImportConfig(ctx, b, update)
+		return
+	}
+	handleSharedLinks(ctx, b, update)
+}
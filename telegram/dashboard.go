@@ -0,0 +1,158 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Room left in a dashboard message for the header and the truncation note.
+const dashboardReserve = 200
+
+func dashboardText(m manifest.Manifest) string {
+	header := fmt.Sprintf("Last check: %v", lastCheck.Format(time.DateTime))
+	if !nextCheck.IsZero() && config.Active {
+		header += fmt.Sprintf("\nNext check: %v", nextCheck.Format(time.DateTime))
+	}
+
+	chunks := m.Render(config.Format, config.Locale, manifest.MessageLimit-dashboardReserve)
+	text := manifest.Escape(config.Format, header) + "\n\n" + chunks[0]
+	if len(chunks) > 1 {
+		text += "\n\n" + manifest.Escape(config.Format, "(list truncated, see /status for all products)")
+	}
+
+	return text
+}
+
+// dashboardGone reports whether editing a dashboard failed, because the
+// message does not exist anymore or cannot be edited at all.
+func dashboardGone(err error) bool {
+	if !errors.Is(err, bot.ErrorBadRequest) {
+		return false
+	}
+	description := err.Error()
+	return strings.Contains(description, "message to edit not found") ||
+		strings.Contains(description, "message can't be edited")
+}
+
+// updateDashboards edits the dashboard message in every notify channel. When
+// a message is gone or cannot be edited anymore, a new one is sent and pinned
+// instead. Other errors are reported and the message is left alone, to be
+// edited again after the next check.
+func updateDashboards(ctx context.Context, b *bot.Bot, m manifest.Manifest) error {
+	text := dashboardText(m)
+	error_slice := []error{}
+	changed := false
+
+	for _, channel := range config.NotifyChannels {
+		if id, ok := config.DashboardMessages[channel]; ok {
+			_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+				ChatID:    channel,
+				MessageID: id,
+				Text:      text,
+				ParseMode: parseMode(config.Format),
+				LinkPreviewOptions: &models.LinkPreviewOptions{
+					IsDisabled: bot.True(),
+				},
+			})
+			if err == nil || strings.Contains(err.Error(), "message is not modified") {
+				continue
+			}
+			if !dashboardGone(err) {
+				error_slice = append(error_slice, errors.Join(ErrorCannotSend, err))
+				continue
+			}
+
+			// the old message may still be there, only too old to edit
+			_, err = b.UnpinChatMessage(ctx, &bot.UnpinChatMessageParams{
+				ChatID:    channel,
+				MessageID: id,
+			})
+			if err != nil {
+				log.Printf("Cannot unpin old dashboard in %s: %v", channel, err)
+			}
+		}
+
+		msg, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    channel,
+			Text:      text,
+			ParseMode: parseMode(config.Format),
+			LinkPreviewOptions: &models.LinkPreviewOptions{
+				IsDisabled: bot.True(),
+			},
+		})
+		if err != nil {
+			error_slice = append(error_slice, errors.Join(ErrorCannotSend, err))
+			continue
+		}
+		config.DashboardMessages[channel] = msg.ID
+		changed = true
+
+		_, err = b.PinChatMessage(ctx, &bot.PinChatMessageParams{
+			ChatID:              channel,
+			MessageID:           msg.ID,
+			DisableNotification: true,
+		})
+		if err != nil {
+			error_slice = append(error_slice, errors.Join(ErrorCannotPin, err))
+		}
+	}
+
+	if changed {
+		err := saveServerConfig("bot", "dashboard messages")
+		if err != nil {
+			error_slice = append(error_slice, errors.Join(ErrorCannotSave, err))
+		}
+	}
+
+	return errors.Join(error_slice...)
+}
+
+// sendChanges sends a short message with only the products that changed.
+func sendChanges(ctx context.Context, b *bot.Bot, m manifest.Manifest, changed []string) error {
+	if len(changed) == 0 {
+		return nil
+	}
+
+	sub := manifest.Manifest{}
+	for _, name := range changed {
+		if a, ok := m[name]; ok {
+			sub[name] = a
+		}
+	}
+	if len(sub) == 0 {
+		return nil
+	}
+
+	error_slice := []error{}
+	for _, channel := range config.NotifyChannels {
+		err := sendManifest(ctx, b, channel, sub)
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+	}
+
+	return errors.Join(error_slice...)
+}
+
+// unpinDashboards unpins the dashboard message of every channel and forgets
+// them. The messages themselves are left in the channels.
+func unpinDashboards(ctx context.Context, b *bot.Bot) {
+	for channel, id := range config.DashboardMessages {
+		_, err := b.UnpinChatMessage(ctx, &bot.UnpinChatMessageParams{
+			ChatID:    channel,
+			MessageID: id,
+		})
+		if err != nil {
+			log.Printf("Cannot unpin dashboard in %s: %v", channel, err)
+		}
+	}
+	config.DashboardMessages = map[string]int{}
+}
@@ -1,7 +1,9 @@
 package telegram
 
 import (
+	"aphoteka_scraper/manifest"
 	"aphoteka_scraper/permanence"
+	"aphoteka_scraper/scraper"
 	"aphoteka_scraper/secrets"
 	"context"
 	"encoding/json"
@@ -36,7 +38,7 @@ func handlerAddUser(ctx context.Context, b *bot.Bot, update *models.Update) {
 	}
 
 	config.Whitelist[username] = unit
-	err := saveServerConfig()
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -83,7 +85,7 @@ func handleRemoveUser(ctx context.Context, b *bot.Bot, update *models.Update) {
 	}
 
 	delete(config.Whitelist, username)
-	err := saveServerConfig()
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -131,7 +133,7 @@ func handleAddChannel(ctx context.Context, b *bot.Bot, update *models.Update) {
 	if !slices.Contains(config.NotifyChannels, channel) {
 		config.NotifyChannels = append(config.NotifyChannels, channel)
 	}
-	err := saveServerConfig()
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -149,6 +151,16 @@ func handleRemoveChannel(ctx context.Context, b *bot.Bot, update *models.Update)
 	channel, ok := strings.CutPrefix(update.Message.Text, "/remove_channel")
 	channel = strings.TrimSpace(channel)
 
+	if ok && len(channel) == 0 && len(config.NotifyChannels) > 0 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        "Pick a channel to stop notifying:",
+			ReplyMarkup: channelPickerMarkup(),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
 	if !ok || len(channel) == 0 {
 		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
@@ -169,7 +181,8 @@ func handleRemoveChannel(ctx context.Context, b *bot.Bot, update *models.Update)
 	}
 
 	config.NotifyChannels = swapRemove(config.NotifyChannels, i)
-	err := saveServerConfig()
+	delete(config.DashboardMessages, channel)
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -199,7 +212,7 @@ func handleAddServiceChannel(ctx context.Context, b *bot.Bot, update *models.Upd
 	if !slices.Contains(config.ServiceChannels, channel) {
 		config.ServiceChannels = append(config.ServiceChannels, channel)
 	}
-	err := saveServerConfig()
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -236,7 +249,7 @@ func handleRemoveServiceChannel(ctx context.Context, b *bot.Bot, update *models.
 	}
 
 	config.ServiceChannels = swapRemove(config.ServiceChannels, i)
-	err := saveServerConfig()
+	err := saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 
 	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
@@ -272,7 +285,7 @@ func handleForceUpdate(ctx context.Context, b *bot.Bot, update *models.Update) {
 	})
 	handleSendError(ctx, b, err)
 
-	checkAndNotify(ctx, b, true)
+	checkAndNotify(ctx, b, checkOptions{force: true})
 
 }
 
@@ -284,7 +297,7 @@ func handleStartUpdates(ctx context.Context, b *bot.Bot, update *models.Update)
 	if !config.Active {
 		setupLoop(ctx, b)
 		config.Active = true
-		err := saveServerConfig()
+		err := saveServerConfig(messageActor(update), update.Message.Text)
 		handleSaveError(ctx, b, err)
 	}
 
@@ -303,7 +316,7 @@ func handleStopUpdates(ctx context.Context, b *bot.Bot, update *models.Update) {
 	if config.Active {
 		loopStopHandle <- unit
 		config.Active = false
-		err := saveServerConfig()
+		err := saveServerConfig(messageActor(update), update.Message.Text)
 		handleSaveError(ctx, b, err)
 	}
 
@@ -341,7 +354,7 @@ func handleSetUpdateInterval(ctx context.Context, b *bot.Bot, update *models.Upd
 	}
 
 	config.Interval = time.Duration(n) * time.Minute
-	err = saveServerConfig()
+	err = saveServerConfig(messageActor(update), update.Message.Text)
 	handleSaveError(ctx, b, err)
 	setupLoop(ctx, b)
 
@@ -357,23 +370,46 @@ func handleAddProduct(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
-	s, ok := strings.CutPrefix(update.Message.Text, "/add_product ")
+	s, ok := strings.CutPrefix(update.Message.Text, "/add_product")
 	slice := strings.Fields(s)
-	if !ok || len(slice) != 2 {
+	if ok && len(slice) == 0 {
+		startConversation(messageConversationKey(update), stepAskUrl)
 		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Syntax: /add_product <name_of_product> <url>",
+			Text:   "Send me the url of the product, or /cancel.",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	if !ok || len(slice) < 2 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /add_product <name of product> <url>, or just /add_product",
 		})
 		handleSendError(ctx, b, err)
 		return
 	}
 
+	// the url never contains spaces, while the name may
+	slice = []string{strings.Join(slice[:len(slice)-1], " "), slice[len(slice)-1]}
+
+	url, _, err := scraper.ValidateProduct(slice[1])
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not added: %v", slice[0], err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	slice[1] = url
+
 	prev_url, overridden := config.Products[slice[0]]
 
 	if !(overridden && prev_url == slice[1]) {
 		config.Products[slice[0]] = slice[1]
 
-		err := saveServerConfig()
+		err := saveServerConfig(messageActor(update), update.Message.Text)
 		handleSaveError(ctx, b, err)
 	}
 
@@ -424,38 +460,104 @@ func handleRemoveProduct(ctx context.Context, b *bot.Bot, update *models.Update)
 		handleSendError(ctx, b, err)
 		return
 	}
-	delete(config.Products, s)
+	forgetProduct(s)
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
 		Text:   fmt.Sprintf("Product %q is deleted.", s),
 	})
 	handleSendError(ctx, b, err)
 }
 
-func handleListProducts(ctx context.Context, b *bot.Bot, update *models.Update) {
+func handleSetConfirmation(ctx context.Context, b *bot.Bot, update *models.Update) {
 	if !checkPermission(ctx, b, update) {
 		return
 	}
 
-	if len(config.Products) == 0 {
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_confirmation ")
+	name, slice := splitProductArgs(strings.Fields(s), 2)
+	if !ok || len(slice) < 1 {
 		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "There are no products tracked.",
+			Text:   "Syntax: /set_confirmation <name of product> <number of checks> [price change in percent]",
 		})
 		handleSendError(ctx, b, err)
 		return
 	}
 
-	var s strings.Builder
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	checks, err := strconv.Atoi(slice[0])
+	if err != nil || checks <= 0 {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Expected positive integer as number of checks",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
 
-	for product, url := range config.Products {
-		fmt.Fprintf(&s, "%s - %s\n", product, url)
+	percent := 0.0
+	if len(slice) == 2 {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(slice[1], "%"), 64)
+		if err != nil || percent < 0 {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Expected non-negative number as price change",
+			})
+			handleSendError(ctx, b, err)
+			return
+		}
 	}
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+	var text string
+	if checks == 1 {
+		delete(config.Confirmations, name)
+		text = fmt.Sprintf("Changes of product %q are reported immediately.", name)
+	} else {
+		config.Confirmations[name] = scraper.ConfirmRule{Checks: checks, PriceChange: percent}
+		text = fmt.Sprintf(
+			"Stock changes and price changes over %.1f%% of product %q must be seen in %d checks in a row.",
+			percent, name, checks,
+		)
+	}
+	err = saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
-		Text:   s.String(),
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleListProducts(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	if len(config.Products) == 0 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   productListText(),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        productListText(),
+		ReplyMarkup: productListMarkup(),
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: bot.True(),
 		},
@@ -463,6 +565,188 @@ func handleListProducts(ctx context.Context, b *bot.Bot, update *models.Update)
 	handleSendError(ctx, b, err)
 }
 
+func handleSetTarget(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_target ")
+	name, slice := splitProductArgs(strings.Fields(s), 2)
+	perUnit := len(slice) == 2 && slice[1] == "per_unit"
+	if !ok || len(slice) == 0 || len(slice) == 2 && !perUnit {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_target <name of product> <price, 0 to clear> [per_unit]",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	price, err := manifest.ParseMoney(slice[0], "")
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Expected non-negative number as price",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	var text string
+	if perUnit {
+		// unit prices may be fractions of a cent
+		unitPrice, _ := strconv.ParseFloat(strings.ReplaceAll(slice[0], ",", "."), 64)
+		text = setUnitTarget(name, unitPrice)
+	} else {
+		text = setTarget(name, uint(price.Cents))
+	}
+	err = saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleSetStockAlert(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_stock_alert ")
+	fields := strings.Fields(s)
+	name, slice := splitProductArgs(fields, len(fields)-1)
+	if !ok || len(slice) == 0 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_stock_alert <name of product> <states, e.g. InStock PreOrder, or off>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	var text string
+	if len(slice) == 1 && slice[0] == "off" {
+		delete(config.StockAlerts, name)
+		text = fmt.Sprintf("Stock alerts of %q are off.", name)
+	} else {
+		states := []manifest.Stock{}
+		wording := []string{}
+		for _, arg := range slice {
+			state, ok := manifest.LookupStock(arg)
+			if !ok {
+				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID: update.Message.Chat.ID,
+					Text: fmt.Sprintf("Unknown stock state %q. Expected InStock, OutOfStock, PreOrder, BackOrder, "+
+						"LimitedAvailability, Discontinued, SoldOut, InStoreOnly, OnlineOnly or Unknown", arg),
+				})
+				handleSendError(ctx, b, err)
+				return
+			}
+			states = append(states, state)
+			wording = append(wording, state.Wording())
+		}
+		config.StockAlerts[name] = states
+		text = fmt.Sprintf("You will be notified when %q is %s.", name, strings.Join(wording, " or "))
+	}
+
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleSetPack(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_pack ")
+	name, slice := splitProductArgs(strings.Fields(s), 2)
+	if !ok || len(slice) == 0 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_pack <name of product> <size, 0 to clear> <pcs, ml, l, g or kg>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	size, err := strconv.ParseFloat(strings.ReplaceAll(slice[0], ",", "."), 64)
+	if err != nil || size < 0 {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Expected non-negative number as pack size",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	var text string
+	if size == 0 {
+		delete(config.Packs, name)
+		text = fmt.Sprintf("Pack size of %q will be read from its name again.", name)
+	} else {
+		unit := ""
+		if len(slice) == 2 {
+			unit = slice[1]
+		}
+		pack, ok := manifest.ParsePack(size, unit)
+		if !ok {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Expected unit to be one of pcs, ml, l, g or kg",
+			})
+			handleSendError(ctx, b, err)
+			return
+		}
+		config.Packs[name] = pack
+		text = fmt.Sprintf("Pack of %q contains %s.", name, pack)
+	}
+
+	err = saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
+
 func handleStatus(ctx context.Context, b *bot.Bot, update *models.Update) {
 	if !checkPermission(ctx, b, update) {
 		return
@@ -480,9 +764,7 @@ func handleStatus(ctx context.Context, b *bot.Bot, update *models.Update) {
 	fmt.Fprintf(&s, "Human readable interval: %v\n", config.Interval)
 
 	lastManifest, err := permanence.LoadManifest()
-	if err == nil {
-		fmt.Fprintf(&s, "Last manifest:\n%s\n\n", bot.EscapeMarkdown(lastManifest.GenerateMessage()))
-	} else {
+	if err != nil {
 		handleError(ctx, b, errors.Join(ErrorCannotLoadManifest, err))
 	}
 
@@ -515,6 +797,11 @@ func handleStatus(ctx context.Context, b *bot.Bot, update *models.Update) {
 		},
 	})
 	handleSendError(ctx, b, err)
+
+	if len(lastManifest) > 0 {
+		err = sendManifest(ctx, b, update.Message.Chat.ID, lastManifest)
+		handleSendError(ctx, b, err)
+	}
 }
 
 func handleCheckNow(ctx context.Context, b *bot.Bot, update *models.Update) {
@@ -528,5 +815,102 @@ func handleCheckNow(ctx context.Context, b *bot.Bot, update *models.Update) {
 	})
 	handleSendError(ctx, b, err)
 
-	checkAndNotify(ctx, b, false)
+	checkAndNotify(ctx, b, checkOptions{})
+}
+
+func handleSetFormat(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_format ")
+	format, valid := manifest.ParseFormat(strings.TrimSpace(s))
+	if !ok || !valid {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_format <plain|markdown|html>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	config.Format = format
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Notifications are formatted as %s.", format),
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleSetLocale(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_locale ")
+	locale, valid := manifest.ParseLocale(strings.TrimSpace(s))
+	if !ok || !valid {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_locale <en|lv>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	config.Locale = locale
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text: fmt.Sprintf("Prices are written like %s.",
+			manifest.FromCents(123456, "EUR").Format(locale)),
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleDashboard(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/dashboard ")
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !ok || (s != "on" && s != "off") {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /dashboard <on|off>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	config.Dashboard = s == "on"
+	if !config.Dashboard {
+		unpinDashboards(ctx, b)
+	}
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	text := "Dashboard mode is off, full manifests are sent on every change."
+	if config.Dashboard {
+		text = "Dashboard mode is on, channels get a pinned status message and short messages about changes."
+
+		lastManifest, err := permanence.LoadManifest()
+		if err != nil {
+			handleError(ctx, b, errors.Join(ErrorCannotLoadManifest, err))
+		} else {
+			handleError(ctx, b, updateDashboards(ctx, b, lastManifest))
+		}
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
 }
@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+var ErrorConfigTooLarge = errors.New("config file is too large")
+
+// Config files larger than this are refused.
+const maxConfigSize = 1 << 20
+
+// At most this many changes are listed before an import.
+const maxDiffLines = 50
+
+func handleExportConfig(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	data, err := encodePortableConfig(config)
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Cannot export the config: %v", err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	_, err = b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID: update.Message.Chat.ID,
+		Document: &models.InputFileUpload{
+			Filename: "aphoteka_config.json",
+			Data:     bytes.NewReader(data),
+		},
+		Caption: "Edit it and send it back with /import_config.",
+	})
+	handleSendError(ctx, b, err)
+}
+
+// handleImportConfig takes the config file attached to the command, or asks
+// for it.
+func handleImportConfig(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	key := messageConversationKey(update)
+	startConversation(key, stepAskConfigFile)
+	c, _ := getConversation(key)
+
+	if update.Message.Document != nil {
+		previewImport(ctx, b, update, c)
+		return
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   "Send the config as a JSON file, as /export_config gives it, or /cancel.",
+	})
+	handleSendError(ctx, b, err)
+}
+
+func downloadDocument(ctx context.Context, b *bot.Bot, doc *models.Document) ([]byte, error) {
+	if doc.FileSize > maxConfigSize {
+		return nil, ErrorConfigTooLarge
+	}
+
+	f, err := b.GetFile(ctx, &bot.GetFileParams{FileID: doc.FileID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(f), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigSize {
+		return nil, ErrorConfigTooLarge
+	}
+
+	return data, nil
+}
+
+// previewImport validates the uploaded config and shows how it differs from
+// the current one, with buttons to apply it.
+func previewImport(ctx context.Context, b *bot.Bot, update *models.Update, c *conversation) {
+	chatID := update.Message.Chat.ID
+
+	var imported serverConfig
+	data, err := downloadDocument(ctx, b, update.Message.Document)
+	if err == nil {
+		imported, err = decodePortableConfig(bytes.NewReader(data), config)
+	}
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   fmt.Sprintf("This config cannot be imported: %v\nSend a fixed file, or /cancel.", err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	c.imported = &imported
+	c.importBase = flattenConfig(config)
+	diff := diffConfigs(config, imported)
+	if len(diff) == 0 {
+		endConversation(messageConversationKey(update))
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "The file is the same as the current config, nothing to import.",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	if len(diff) > maxDiffLines {
+		diff = append(diff[:maxDiffLines], fmt.Sprintf("... and %d more changes", len(diff)-maxDiffLines))
+	}
+
+	c.step = stepConfirmImport
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "Importing will change:\n" + strings.Join(diff, "\n"),
+		ReplyMarkup: &models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: "✅ Import", CallbackData: "i:ok"},
+				{Text: "Cancel", CallbackData: "i:cancel"},
+			}},
+		},
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleImportCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkCallbackPermission(ctx, b, update) {
+		return
+	}
+
+	key := callbackConversationKey(update)
+	c, ok := getConversation(key)
+	if !ok || c.step != stepConfirmImport {
+		editCallbackMessage(ctx, b, update, "This import has expired. Start again with /import_config.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+	endConversation(key)
+
+	if update.CallbackQuery.Data != "i:ok" {
+		editCallbackMessage(ctx, b, update, "Import is cancelled.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	// the preview is only right for the config it was made against
+	if !maps.Equal(flattenConfig(config), c.importBase) {
+		editCallbackMessage(ctx, b, update,
+			"The config has changed since this preview, nothing is imported. Send the file again with /import_config.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	prev := config
+	config = *c.imported
+	err := saveServerConfig(callbackActor(update), "/import_config")
+	handleSaveError(ctx, b, err)
+
+	// the update loop only follows the active flag and the interval, when
+	// it is set up again
+	if config.Active && (!prev.Active || prev.Interval != config.Interval) {
+		setupLoop(ctx, b)
+	} else if !config.Active && prev.Active {
+		loopStopHandle <- unit
+	}
+
+	editCallbackMessage(ctx, b, update, "Config imported.", nil)
+	answerCallback(ctx, b, update, "")
+}
@@ -0,0 +1,238 @@
+package telegram
+
+import (
+	"aphoteka_scraper/scraper"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// How often repeated service errors are summarized.
+const summaryInterval = 6 * time.Hour
+
+// Errors that name a class of problems. An error wrapping one of these is
+// grouped by it, instead of by its full message.
+var knownErrors = []error{
+	ErrorCannotSetCommands,
+	ErrorCannotSend,
+	ErrorCannotSave,
+	ErrorCannotAudit,
+	ErrorCannotDumpManifest,
+	ErrorCannotLoadManifest,
+	scraper.ErrorEmptyData,
+	scraper.ErrorCannotParse,
+}
+
+type incidentKey struct {
+	class   string
+	product string
+}
+
+// incident is a service error, that has been reported once. Repeats are only
+// counted until the next summary.
+type incident struct {
+	message   string
+	first     time.Time
+	last      time.Time
+	repeats   int
+	fromCheck bool
+	seen      bool
+}
+
+var incidents = map[incidentKey]*incident{}
+var incidentsMutex sync.Mutex
+
+// splitErrors breaks joined errors apart into independent problems. Joins that
+// wrap a known error are kept together, since they describe a single problem.
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *scraper.ProductError, *WatchError:
+		return []error{err}
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	for _, e := range joined.Unwrap() {
+		for _, known := range knownErrors {
+			if e == known {
+				return []error{err}
+			}
+		}
+	}
+
+	result := []error{}
+	for _, e := range joined.Unwrap() {
+		result = append(result, splitErrors(e)...)
+	}
+	return result
+}
+
+func classify(err error) incidentKey {
+	key := incidentKey{}
+
+	var pe *scraper.ProductError
+	var we *WatchError
+	if errors.As(err, &pe) {
+		key.product = pe.Product
+		err = pe.Err
+	} else if errors.As(err, &we) {
+		key.product = watchIncident(we.Watch)
+		err = we.Err
+	}
+
+	for _, known := range knownErrors {
+		if errors.Is(err, known) {
+			key.class = known.Error()
+			return key
+		}
+	}
+
+	key.class, _, _ = strings.Cut(err.Error(), "\n")
+	return key
+}
+
+// recordErrors registers every problem in err and returns messages for the
+// ones that were not known yet.
+func recordErrors(err error, fromCheck bool) []string {
+	incidentsMutex.Lock()
+	defer incidentsMutex.Unlock()
+
+	now := time.Now()
+	fresh := []string{}
+
+	for _, e := range splitErrors(err) {
+		key := classify(e)
+		inc, ok := incidents[key]
+		if ok {
+			inc.repeats++
+			inc.last = now
+			inc.seen = true
+			continue
+		}
+
+		incidents[key] = &incident{
+			message:   e.Error(),
+			first:     now,
+			last:      now,
+			fromCheck: fromCheck,
+			seen:      true,
+		}
+		fresh = append(fresh, e.Error())
+	}
+
+	return fresh
+}
+
+// resolveCheckErrors forgets problems from checks, that did not show up in
+// the last check. Problems of a product are only forgotten, when it was
+// actually fetched: a product on a domain, that is backing off or degraded,
+// keeps its problem until the domain answers again. Returns their messages.
+func resolveCheckErrors(fetched []string) []string {
+	incidentsMutex.Lock()
+	defer incidentsMutex.Unlock()
+
+	resolved := []string{}
+	for key, inc := range incidents {
+		if !inc.fromCheck {
+			continue
+		}
+		if key.product != "" && !slices.Contains(fetched, key.product) {
+			continue
+		}
+		if !inc.seen {
+			resolved = append(resolved, inc.message)
+			delete(incidents, key)
+			continue
+		}
+		inc.seen = false
+	}
+	sort.Strings(resolved)
+
+	return resolved
+}
+
+// reportCheckErrors is handleError for errors of a whole check: problems that
+// are gone since the previous check are reported as resolved. fetched lists
+// the products, and the watches named by watchIncident, that the check
+// actually got an answer for.
+func reportCheckErrors(ctx context.Context, b *bot.Bot, err error, fetched []string) {
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, m := range recordErrors(err, true) {
+		sendServiceError(ctx, b, m)
+	}
+
+	for _, m := range resolveCheckErrors(fetched) {
+		sendServiceError(ctx, b, "Resolved:\n"+m)
+	}
+}
+
+func sendServiceError(ctx context.Context, b *bot.Bot, m string) {
+	err := notifyService(ctx, b, m)
+	if err != nil {
+		log.Printf("Error sending service notifications: %v", err)
+	}
+}
+
+// summarizeIncidents builds the periodic summary of repeated errors. Errors,
+// which did not come from checks and did not repeat since the previous
+// summary, are considered resolved.
+func summarizeIncidents(since time.Time) string {
+	incidentsMutex.Lock()
+	defer incidentsMutex.Unlock()
+
+	lines := []string{}
+	for key, inc := range incidents {
+		if inc.repeats > 0 {
+			lines = append(lines, fmt.Sprintf("%s\n%d more occurrences, first seen %v",
+				inc.message, inc.repeats, inc.first.Format(time.DateTime)))
+			inc.repeats = 0
+			continue
+		}
+		if !inc.fromCheck && inc.last.Before(since) {
+			lines = append(lines, "Resolved:\n"+inc.message)
+			delete(incidents, key)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+
+	return "Error summary:\n\n" + strings.Join(lines, "\n\n")
+}
+
+func runIncidentSummaries(ctx context.Context, b *bot.Bot) {
+	ticker := time.NewTicker(summaryInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if m := summarizeIncidents(last); m != "" {
+				sendServiceError(ctx, b, m)
+			}
+			last = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Telegram allows only 64 bytes of callback data, so product names and
+// channels are replaced by short references. References live in memory, so
+// buttons sent before a restart stop working.
+var callbackRefs = map[string]string{}
+var callbackValues = map[string]string{}
+var callbackMutex sync.Mutex
+
+func callbackRef(value string) string {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+
+	if ref, ok := callbackValues[value]; ok {
+		return ref
+	}
+
+	ref := strconv.FormatInt(int64(len(callbackRefs)), 36)
+	callbackRefs[ref] = value
+	callbackValues[value] = ref
+
+	return ref
+}
+
+func callbackValue(ref string) (string, bool) {
+	callbackMutex.Lock()
+	defer callbackMutex.Unlock()
+
+	value, ok := callbackRefs[ref]
+	return value, ok
+}
+
+func checkCallbackPermission(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	if _, ok := config.Whitelist["@"+update.CallbackQuery.From.Username]; ok {
+		log.Printf("Callback: %q", update.CallbackQuery.Data)
+		return true
+	} else {
+		answerCallback(ctx, b, update, "Unauthorized. Sorry.")
+		return false
+	}
+}
+
+func answerCallback(ctx context.Context, b *bot.Bot, update *models.Update, text string) {
+	_, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: update.CallbackQuery.ID,
+		Text:            text,
+	})
+	handleSendError(ctx, b, err)
+}
+
+// editCallbackMessage replaces the message, that the pressed button belongs
+// to. Without markup the keyboard is removed.
+func editCallbackMessage(ctx context.Context, b *bot.Bot, update *models.Update, text string, markup *models.InlineKeyboardMarkup) {
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		return
+	}
+
+	params := &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	}
+	if markup != nil {
+		params.ReplyMarkup = markup
+	}
+
+	_, err := b.EditMessageText(ctx, params)
+	handleSendError(ctx, b, err)
+}
+
+func callbackChatID(update *models.Update) any {
+	if msg := update.CallbackQuery.Message.Message; msg != nil {
+		return msg.Chat.ID
+	}
+	return update.CallbackQuery.From.ID
+}
+
+func sortedProducts() []string {
+	names := []string{}
+	for name := range config.Products {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func productListText() string {
+	if len(config.Products) == 0 {
+		return "There are no products tracked."
+	}
+
+	var s strings.Builder
+	for _, name := range sortedProducts() {
+		fmt.Fprintf(&s, "%s - %s", name, config.Products[name])
+		if _, paused := config.Paused[name]; paused {
+			s.WriteString(" (paused)")
+		}
+		s.WriteString("\n")
+	}
+
+	return s.String()
+}
+
+func productListMarkup() *models.InlineKeyboardMarkup {
+	rows := [][]models.InlineKeyboardButton{}
+	for i, name := range sortedProducts() {
+		button := models.InlineKeyboardButton{
+			Text:         name,
+			CallbackData: "p:menu:" + callbackRef(name),
+		}
+		if i%2 == 0 {
+			rows = append(rows, []models.InlineKeyboardButton{button})
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func productMenu(name string) (string, *models.InlineKeyboardMarkup) {
+	ref := callbackRef(name)
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s\n%s\n", name, config.Products[name])
+
+	lastManifest, err := permanence.LoadManifest()
+	if a, ok := lastManifest[name]; err == nil && ok && a.Found {
+		fmt.Fprintf(&s, "Last price: %s\n", a.Price.Format(config.Locale))
+		if note := a.UnitPriceNote(config.Locale); note != "" {
+			fmt.Fprintf(&s, "Unit price: %s\n", note)
+		}
+	}
+	if target, ok := config.Targets[name]; ok {
+		fmt.Fprintf(&s, "Target price: %s\n", manifest.FromCents(int64(target), "").Format(config.Locale))
+	}
+	if target, ok := config.UnitTargets[name]; ok {
+		fmt.Fprintf(&s, "Target unit price: %g\n", target)
+	}
+
+	pause := models.InlineKeyboardButton{Text: "⏸ Pause", CallbackData: "p:pause:" + ref}
+	if _, paused := config.Paused[name]; paused {
+		s.WriteString("Paused\n")
+		pause = models.InlineKeyboardButton{Text: "▶️ Resume", CallbackData: "p:pause:" + ref}
+	}
+
+	return s.String(), &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "🗑 Remove", CallbackData: "p:rm:" + ref},
+				pause,
+			},
+			{
+				{Text: "📈 Chart", CallbackData: "p:chart:" + ref},
+				{Text: "🎯 Set target", CallbackData: "p:target:" + ref},
+			},
+			{
+				{Text: "« Back", CallbackData: "p:list"},
+			},
+		},
+	}
+}
+
+// targetMenu offers target prices relative to the last known price.
+func targetMenu(name string) (string, *models.InlineKeyboardMarkup) {
+	ref := callbackRef(name)
+	back := []models.InlineKeyboardButton{{Text: "« Back", CallbackData: "p:menu:" + ref}}
+
+	lastManifest, err := permanence.LoadManifest()
+	a, ok := lastManifest[name]
+	if err != nil || !ok || a.Price.IsZero() {
+		return fmt.Sprintf("No price of %q is known yet. Use /set_target <name_of_product> <price>.", name),
+			&models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{back}}
+	}
+
+	row := []models.InlineKeyboardButton{}
+	for _, percent := range []int64{5, 10, 20} {
+		price := manifest.FromCents(a.Price.Cents*(100-percent)/100, a.Price.Currency)
+		row = append(row, models.InlineKeyboardButton{
+			Text:         fmt.Sprintf("-%d%% (%s)", percent, price.Format(config.Locale)),
+			CallbackData: fmt.Sprintf("p:tgt:%s:%d", ref, price.Cents),
+		})
+	}
+
+	return fmt.Sprintf("Notify when %q costs at most:", name), &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			row,
+			{{Text: "Clear target", CallbackData: fmt.Sprintf("p:tgt:%s:0", ref)}},
+			back,
+		},
+	}
+}
+
+func handleProductCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkCallbackPermission(ctx, b, update) {
+		return
+	}
+
+	parts := strings.Split(update.CallbackQuery.Data, ":")
+	if len(parts) < 2 {
+		answerCallback(ctx, b, update, "Unknown button.")
+		return
+	}
+	action := parts[1]
+
+	if action == "list" || len(parts) < 3 {
+		editCallbackMessage(ctx, b, update, productListText(), productListMarkup())
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	name, ok := callbackValue(parts[2])
+	if _, tracked := config.Products[name]; !ok || !tracked {
+		editCallbackMessage(ctx, b, update, productListText(), productListMarkup())
+		answerCallback(ctx, b, update, "This product is not tracked anymore.")
+		return
+	}
+	ref := parts[2]
+
+	switch action {
+	case "menu":
+		text, markup := productMenu(name)
+		editCallbackMessage(ctx, b, update, text, markup)
+		answerCallback(ctx, b, update, "")
+
+	case "rm":
+		editCallbackMessage(ctx, b, update, fmt.Sprintf("Stop tracking %q?", name), &models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: "Yes, remove", CallbackData: "p:rmyes:" + ref},
+				{Text: "Cancel", CallbackData: "p:menu:" + ref},
+			}},
+		})
+		answerCallback(ctx, b, update, "")
+
+	case "rmyes":
+		forgetProduct(name)
+		err := saveServerConfig(callbackActor(update), "remove product "+name)
+		handleSaveError(ctx, b, err)
+
+		editCallbackMessage(ctx, b, update, productListText(), productListMarkup())
+		answerCallback(ctx, b, update, fmt.Sprintf("Product %q is deleted.", name))
+
+	case "pause":
+		text := fmt.Sprintf("Product %q is paused.", name)
+		if _, paused := config.Paused[name]; paused {
+			delete(config.Paused, name)
+			text = fmt.Sprintf("Product %q is tracked again.", name)
+		} else {
+			config.Paused[name] = unit
+		}
+		err := saveServerConfig(callbackActor(update), "pause or resume "+name)
+		handleSaveError(ctx, b, err)
+
+		menu, markup := productMenu(name)
+		editCallbackMessage(ctx, b, update, menu, markup)
+		answerCallback(ctx, b, update, text)
+
+	case "chart":
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: callbackChatID(update),
+			Text:   chartText(name),
+		})
+		handleSendError(ctx, b, err)
+		answerCallback(ctx, b, update, "")
+
+	case "target":
+		text, markup := targetMenu(name)
+		editCallbackMessage(ctx, b, update, text, markup)
+		answerCallback(ctx, b, update, "")
+
+	case "tgt":
+		var price uint64
+		var err error
+		if len(parts) == 4 {
+			price, err = strconv.ParseUint(parts[3], 10, 0)
+		}
+		if len(parts) != 4 || err != nil {
+			answerCallback(ctx, b, update, "Unknown button.")
+			return
+		}
+
+		text := setTarget(name, uint(price))
+		err = saveServerConfig(callbackActor(update), "set target of "+name)
+		handleSaveError(ctx, b, err)
+
+		menu, markup := productMenu(name)
+		editCallbackMessage(ctx, b, update, menu, markup)
+		answerCallback(ctx, b, update, text)
+
+	default:
+		answerCallback(ctx, b, update, "Unknown button.")
+	}
+}
+
+// setTarget sets the target price of a product, zero clears it. Returns a
+// message describing the change.
+func setTarget(name string, price uint) string {
+	if price == 0 {
+		delete(config.Targets, name)
+		return fmt.Sprintf("Target price of %q is cleared.", name)
+	}
+
+	config.Targets[name] = price
+	return fmt.Sprintf("You will be notified when %q costs at most %s.", name,
+		manifest.FromCents(int64(price), "").Format(config.Locale))
+}
+
+// setUnitTarget sets the target price of a single piece, millilitre or gram.
+func setUnitTarget(name string, price float64) string {
+	if price == 0 {
+		delete(config.UnitTargets, name)
+		return fmt.Sprintf("Target unit price of %q is cleared.", name)
+	}
+
+	config.UnitTargets[name] = price
+	return fmt.Sprintf("You will be notified when a unit of %q costs at most %g.", name, price)
+}
+
+func channelPickerMarkup() *models.InlineKeyboardMarkup {
+	channels := slices.Clone(config.NotifyChannels)
+	sort.Strings(channels)
+
+	rows := [][]models.InlineKeyboardButton{}
+	for _, channel := range channels {
+		rows = append(rows, []models.InlineKeyboardButton{{
+			Text:         channel,
+			CallbackData: "c:ask:" + callbackRef(channel),
+		}})
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func handleChannelCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkCallbackPermission(ctx, b, update) {
+		return
+	}
+
+	parts := strings.Split(update.CallbackQuery.Data, ":")
+	if len(parts) < 2 {
+		answerCallback(ctx, b, update, "Unknown button.")
+		return
+	}
+
+	if parts[1] == "list" || len(parts) < 3 {
+		editCallbackMessage(ctx, b, update, "Pick a channel to stop notifying:", channelPickerMarkup())
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	channel, ok := callbackValue(parts[2])
+	i := slices.Index(config.NotifyChannels, channel)
+	if !ok || i == -1 {
+		editCallbackMessage(ctx, b, update, "Pick a channel to stop notifying:", channelPickerMarkup())
+		answerCallback(ctx, b, update, "This channel is not notified anymore.")
+		return
+	}
+
+	switch parts[1] {
+	case "ask":
+		editCallbackMessage(ctx, b, update, fmt.Sprintf("Stop notifying channel %q?", channel), &models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: "Yes, remove", CallbackData: "c:rmyes:" + parts[2]},
+				{Text: "Cancel", CallbackData: "c:list"},
+			}},
+		})
+		answerCallback(ctx, b, update, "")
+
+	case "rmyes":
+		config.NotifyChannels = swapRemove(config.NotifyChannels, i)
+		delete(config.DashboardMessages, channel)
+		err := saveServerConfig(callbackActor(update), "remove channel "+channel)
+		handleSaveError(ctx, b, err)
+
+		editCallbackMessage(ctx, b, update, fmt.Sprintf("Channel %q will not be notified anymore.", channel), nil)
+		answerCallback(ctx, b, update, "")
+
+	default:
+		answerCallback(ctx, b, update, "Unknown button.")
+	}
+}
+
+// chartText draws the price history of a product as a sparkline.
+func chartText(name string) string {
+	const maxPoints = 30
+	const levels = "▁▂▃▄▅▆▇█"
+
+	history, err := permanence.LoadHistory(name)
+	if err != nil {
+		log.Print(errors.Join(ErrorCannotLoadHistory, err))
+		return fmt.Sprintf("Cannot load price history of %q.", name)
+	}
+
+	found := []permanence.HistoryEntry{}
+	for _, entry := range history {
+		if entry.Availability.Found {
+			found = append(found, entry)
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Sprintf("No price history of %q yet.", name)
+	}
+	if len(found) > maxPoints {
+		found = found[len(found)-maxPoints:]
+	}
+
+	since := found[0].Time.Format("02.01.2006")
+	last := found[len(found)-1].Availability
+	prices := []int64{}
+	for _, entry := range found {
+		prices = append(prices, entry.Availability.Price.Cents)
+	}
+
+	low, high := slices.Min(prices), slices.Max(prices)
+	bars := []rune(levels)
+
+	var s strings.Builder
+	for _, price := range prices {
+		i := len(bars) / 2
+		if high > low {
+			i = int((price - low) * int64(len(bars)-1) / (high - low))
+		}
+		s.WriteRune(bars[i])
+	}
+
+	currency := last.Price.Currency
+	text := fmt.Sprintf("Price history of %q since %s:\n%s\nLowest %s, highest %s, last %s",
+		name, since, s.String(),
+		manifest.FromCents(low, currency).Format(config.Locale),
+		manifest.FromCents(high, currency).Format(config.Locale),
+		last.Price.Format(config.Locale),
+	)
+	if note := last.UnitPriceNote(config.Locale); note != "" {
+		text += fmt.Sprintf("\nLast unit price %s, pack of %s", note, last.Pack)
+	}
+
+	return text
+}
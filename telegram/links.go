@@ -0,0 +1,244 @@
+package telegram
+
+import (
+	"aphoteka_scraper/scraper"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// At most this many links of a single message are looked at.
+const maxSharedLinks = 3
+
+// Products found through shared links, by normalized url, until someone
+// decides to track them.
+var sharedProducts = map[string]scraper.ProductInfo{}
+var sharedProductsMutex sync.Mutex
+
+// messageUrls returns all links in a message, both plain and hidden behind
+// text. Entity offsets are counted in UTF-16 units.
+func messageUrls(msg *models.Message) []string {
+	text, entities := msg.Text, msg.Entities
+	if text == "" {
+		text, entities = msg.Caption, msg.CaptionEntities
+	}
+	encoded := utf16.Encode([]rune(text))
+
+	urls := []string{}
+	for _, entity := range entities {
+		switch entity.Type {
+		case models.MessageEntityTypeURL:
+			end := entity.Offset + entity.Length
+			if entity.Offset < 0 || end > len(encoded) {
+				continue
+			}
+			urls = append(urls, string(utf16.Decode(encoded[entity.Offset:end])))
+		case models.MessageEntityTypeTextLink:
+			urls = append(urls, entity.URL)
+		}
+	}
+
+	return urls
+}
+
+// uniqueProductName returns name, or name with a number, if another product
+// already uses it.
+func uniqueProductName(name string) string {
+	if _, exists := config.Products[name]; !exists {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if _, exists := config.Products[candidate]; !exists {
+			return candidate
+		}
+	}
+}
+
+func trackedName(url string) (string, bool) {
+	for name, u := range config.Products {
+		if u == url {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// handleSharedLinks replies to every supported shop link in a message of a
+// whitelisted user with what is on the page and a button to track it.
+// Messages of other users are ignored silently, since links are posted in
+// group chats all the time.
+func handleSharedLinks(ctx context.Context, b *bot.Bot, update *models.Update) {
+	msg := update.Message
+	if msg.From == nil {
+		return
+	}
+	if _, ok := config.Whitelist["@"+msg.From.Username]; !ok {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, raw := range messageUrls(msg) {
+		if len(seen) >= maxSharedLinks {
+			break
+		}
+
+		url, err := scraper.NormalizeUrl(raw)
+		if err != nil || seen[url] {
+			continue
+		}
+		seen[url] = true
+		log.Printf("Shared link: %q", url)
+
+		if name, ok := trackedName(url); ok {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: msg.Chat.ID,
+				Text:   fmt.Sprintf("This product is already tracked as %q.", name),
+			})
+			handleSendError(ctx, b, err)
+			continue
+		}
+
+		info, err := scraper.FetchProduct(url)
+		if err != nil {
+			_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: msg.Chat.ID,
+				Text:   fmt.Sprintf("This product cannot be tracked: %v", err),
+			})
+			handleSendError(ctx, b, err)
+			continue
+		}
+
+		sharedProductsMutex.Lock()
+		sharedProducts[url] = info
+		sharedProductsMutex.Unlock()
+
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: msg.Chat.ID,
+			Text:   fmt.Sprintf("%s\n%s", info.Name, describeProduct(info)),
+			ReplyParameters: &models.ReplyParameters{
+				MessageID: msg.ID,
+			},
+			ReplyMarkup: &models.InlineKeyboardMarkup{
+				InlineKeyboard: [][]models.InlineKeyboardButton{{
+					{Text: "➕ Track this", CallbackData: "t:" + callbackRef(url)},
+				}},
+			},
+		})
+		handleSendError(ctx, b, err)
+	}
+}
+
+func handleTrackCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkCallbackPermission(ctx, b, update) {
+		return
+	}
+
+	url, ok := callbackValue(update.CallbackQuery.Data[len("t:"):])
+	sharedProductsMutex.Lock()
+	info, found := sharedProducts[url]
+	sharedProductsMutex.Unlock()
+	if !ok || !found {
+		editCallbackMessage(ctx, b, update, "This button has expired, share the link again.", nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	if name, tracked := trackedName(url); tracked {
+		editCallbackMessage(ctx, b, update, fmt.Sprintf("This product is already tracked as %q.", name), nil)
+		answerCallback(ctx, b, update, "")
+		return
+	}
+
+	name := info.Name
+	if name == "" {
+		name = url
+	}
+	name = uniqueProductName(name)
+
+	config.Products[name] = url
+	err := saveServerConfig(callbackActor(update), "track shared link "+url)
+	handleSaveError(ctx, b, err)
+
+	sharedProductsMutex.Lock()
+	delete(sharedProducts, url)
+	sharedProductsMutex.Unlock()
+
+	editCallbackMessage(ctx, b, update, fmt.Sprintf("Product %q added with url %q.", name, url), nil)
+	answerCallback(ctx, b, update, "")
+}
+
+// How many products /search shows.
+const searchResults = 5
+
+func handleSearch(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	query, ok := strings.CutPrefix(update.Message.Text, "/search ")
+	query = strings.TrimSpace(query)
+	if !ok || query == "" {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /search <query>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   "Searching...",
+	})
+	handleSendError(ctx, b, err)
+
+	results, err := scraper.Search(query, searchResults)
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Search failed: %v", err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	if len(results) == 0 {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Nothing found for %q.", query),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	var s strings.Builder
+	rows := [][]models.InlineKeyboardButton{}
+	sharedProductsMutex.Lock()
+	for i, info := range results {
+		url := info.Availability.Url
+		sharedProducts[url] = info
+
+		fmt.Fprintf(&s, "%d. %s\n%s\n%s\n\n", i+1, info.Name, describeProduct(info), url)
+		rows = append(rows, []models.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("➕ Track %d. %s", i+1, info.Name),
+			CallbackData: "t:" + callbackRef(url),
+		}})
+	}
+	sharedProductsMutex.Unlock()
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        strings.TrimSpace(s.String()),
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: rows},
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+	handleSendError(ctx, b, err)
+}
@@ -0,0 +1,449 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"aphoteka_scraper/scraper"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorInvalidConfig = errors.New("invalid config file")
+
+// portableConfig is the config as a JSON document, that people can read and
+// edit. Settings of a product are kept together with the product, instead
+// of a map per setting.
+type portableConfig struct {
+	Users           []string                   `json:"users"`
+	NotifyChannels  []string                   `json:"notify_channels"`
+	ServiceChannels []string                   `json:"service_channels"`
+	Products        map[string]portableProduct `json:"products"`
+	Watches         map[string]string          `json:"watches,omitempty"`
+	Active          bool                       `json:"active"`
+	IntervalMinutes int                        `json:"interval_minutes"`
+	Format          manifest.Format            `json:"format"`
+	Locale          manifest.Locale            `json:"locale"`
+	Dashboard       bool                       `json:"dashboard"`
+}
+
+type portableProduct struct {
+	Url         string               `json:"url"`
+	Paused      bool                 `json:"paused,omitempty"`
+	Target      string               `json:"target,omitempty"`
+	UnitTarget  float64              `json:"unit_target,omitempty"`
+	Pack        *portablePack        `json:"pack,omitempty"`
+	StockAlerts []string             `json:"stock_alerts,omitempty"`
+	CityAlert   string               `json:"city_alert,omitempty"`
+	Confirm     *scraper.ConfirmRule `json:"confirm,omitempty"`
+}
+
+type portablePack struct {
+	Size float64 `json:"size"`
+	Unit string  `json:"unit"`
+}
+
+func exportConfig(c serverConfig) portableConfig {
+	p := portableConfig{
+		Users:           []string{},
+		NotifyChannels:  slices.Clone(c.NotifyChannels),
+		ServiceChannels: slices.Clone(c.ServiceChannels),
+		Products:        map[string]portableProduct{},
+		Watches:         maps.Clone(c.Watches),
+		Active:          c.Active,
+		IntervalMinutes: int(c.Interval / time.Minute),
+		Format:          c.Format,
+		Locale:          c.Locale,
+		Dashboard:       c.Dashboard,
+	}
+
+	for user := range c.Whitelist {
+		p.Users = append(p.Users, user)
+	}
+	sort.Strings(p.Users)
+
+	for name, url := range c.Products {
+		product := portableProduct{
+			Url:        url,
+			UnitTarget: c.UnitTargets[name],
+			CityAlert:  c.CityAlerts[name],
+		}
+		for _, state := range c.StockAlerts[name] {
+			product.StockAlerts = append(product.StockAlerts, state.String())
+		}
+		if _, paused := c.Paused[name]; paused {
+			product.Paused = true
+		}
+		if target, ok := c.Targets[name]; ok {
+			product.Target = manifest.FromCents(int64(target), "").String()
+		}
+		if pack, ok := c.Packs[name]; ok {
+			product.Pack = &portablePack{Size: pack.Size, Unit: pack.Unit}
+		}
+		if rule, ok := c.Confirmations[name]; ok {
+			product.Confirm = &rule
+		}
+		p.Products[name] = product
+	}
+
+	return p
+}
+
+// importConfig checks a portable config and turns it into a server config.
+// State, that is not configured by people, like dashboard messages, is taken
+// from base.
+func importConfig(p portableConfig, base serverConfig) (serverConfig, error) {
+	// the root user is always whitelisted by newServerConfig
+	c := newServerConfig()
+	c.DashboardMessages = maps.Clone(base.DashboardMessages)
+	e := []error{}
+
+	for _, user := range p.Users {
+		if !strings.HasPrefix(user, "@") || len(user) < 2 {
+			e = append(e, fmt.Errorf("user %q has to be a username starting with @", user))
+			continue
+		}
+		c.Whitelist[user] = unit
+	}
+
+	for _, channel := range p.NotifyChannels {
+		if strings.TrimSpace(channel) == "" {
+			e = append(e, errors.New("notification channel cannot be empty"))
+			continue
+		}
+		c.NotifyChannels = append(c.NotifyChannels, channel)
+	}
+	for _, channel := range p.ServiceChannels {
+		if strings.TrimSpace(channel) == "" {
+			e = append(e, errors.New("service channel cannot be empty"))
+			continue
+		}
+		c.ServiceChannels = append(c.ServiceChannels, channel)
+	}
+
+	for name, product := range p.Products {
+		if strings.TrimSpace(name) == "" {
+			e = append(e, errors.New("product name cannot be empty"))
+			continue
+		}
+		url, err := scraper.NormalizeUrl(product.Url)
+		if err != nil {
+			e = append(e, fmt.Errorf("product %q: %w", name, err))
+			continue
+		}
+		c.Products[name] = url
+
+		if product.Paused {
+			c.Paused[name] = unit
+		}
+		if product.Target != "" {
+			price, err := manifest.ParseMoney(product.Target, "")
+			if err != nil || price.IsZero() {
+				e = append(e, fmt.Errorf("product %q: target %q is not a positive price", name, product.Target))
+			} else {
+				c.Targets[name] = uint(price.Cents)
+			}
+		}
+		if product.UnitTarget < 0 {
+			e = append(e, fmt.Errorf("product %q: unit target cannot be negative", name))
+		} else if product.UnitTarget > 0 {
+			c.UnitTargets[name] = product.UnitTarget
+		}
+		if product.Pack != nil {
+			pack, ok := manifest.ParsePack(product.Pack.Size, product.Pack.Unit)
+			if !ok {
+				e = append(e, fmt.Errorf("product %q: pack %g %s is not understood", name, product.Pack.Size, product.Pack.Unit))
+			} else {
+				c.Packs[name] = pack
+			}
+		}
+		// the same states as /set_stock_alert takes
+		states := []manifest.Stock{}
+		for _, arg := range product.StockAlerts {
+			state, ok := manifest.LookupStock(arg)
+			if !ok {
+				e = append(e, fmt.Errorf("product %q: stock state %q in stock alerts is not known", name, arg))
+			}
+			states = append(states, state)
+		}
+		if len(states) > 0 {
+			c.StockAlerts[name] = states
+		}
+		if product.CityAlert != "" {
+			c.CityAlerts[name] = product.CityAlert
+		}
+		if product.Confirm != nil {
+			if product.Confirm.Checks < 1 || product.Confirm.PriceChange < 0 {
+				e = append(e, fmt.Errorf("product %q: confirmation needs at least 1 check and a non-negative price change", name))
+			} else {
+				c.Confirmations[name] = *product.Confirm
+			}
+		}
+	}
+
+	for name, raw := range p.Watches {
+		url, err := scraper.NormalizeUrl(raw)
+		if err != nil {
+			e = append(e, fmt.Errorf("watch %q: %w", name, err))
+			continue
+		}
+		c.Watches[name] = url
+	}
+
+	c.Active = p.Active
+	if p.IntervalMinutes <= 0 {
+		e = append(e, errors.New("interval_minutes has to be a positive number"))
+	} else {
+		c.Interval = time.Duration(p.IntervalMinutes) * time.Minute
+	}
+	if format, ok := manifest.ParseFormat(string(p.Format)); ok {
+		c.Format = format
+	} else {
+		e = append(e, fmt.Errorf("format %q is not one of plain, markdown or html", p.Format))
+	}
+	if locale, ok := manifest.ParseLocale(string(p.Locale)); ok {
+		c.Locale = locale
+	} else {
+		e = append(e, fmt.Errorf("locale %q is not one of en or lv", p.Locale))
+	}
+	c.Dashboard = p.Dashboard
+
+	if len(e) > 0 {
+		return serverConfig{}, errors.Join(append([]error{ErrorInvalidConfig}, e...)...)
+	}
+
+	return c, nil
+}
+
+func encodePortableConfig(c serverConfig) ([]byte, error) {
+	return json.MarshalIndent(exportConfig(c), "", "  ")
+}
+
+// decodePortableConfig reads a portable config. Unknown keys are refused, so
+// that a typo does not silently drop a setting.
+func decodePortableConfig(r io.Reader, base serverConfig) (serverConfig, error) {
+	return decodeOnto(r, portableConfig{}, base)
+}
+
+// decodeBootstrapConfig reads a bootstrap file, which is a portable config
+// whose keys left out get their default value.
+func decodeBootstrapConfig(r io.Reader, base serverConfig) (serverConfig, error) {
+	return decodeOnto(r, exportConfig(newServerConfig()), base)
+}
+
+// decodeOnto reads a portable config over p.
+func decodeOnto(r io.Reader, p portableConfig, base serverConfig) (serverConfig, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		return serverConfig{}, errors.Join(ErrorInvalidConfig, err)
+	}
+
+	return importConfig(p, base)
+}
+
+// flattenConfig lists every setting as a path and a value, to compare
+// configs line by line. Settings of products are JSON values.
+func flattenConfig(c serverConfig) map[string]string {
+	p := exportConfig(c)
+	flat := map[string]string{
+		"active":           strconv.FormatBool(p.Active),
+		"interval_minutes": strconv.Itoa(p.IntervalMinutes),
+		"format":           string(p.Format),
+		"locale":           string(p.Locale),
+		"dashboard":        strconv.FormatBool(p.Dashboard),
+	}
+	for _, user := range p.Users {
+		flat["users."+user] = "yes"
+	}
+	for _, channel := range p.NotifyChannels {
+		flat["notify_channels."+channel] = "yes"
+	}
+	for _, channel := range p.ServiceChannels {
+		flat["service_channels."+channel] = "yes"
+	}
+	for name, url := range p.Watches {
+		flat["watches."+name] = url
+	}
+	for name, product := range p.Products {
+		data, _ := json.Marshal(product)
+		var fields map[string]json.RawMessage
+		json.Unmarshal(data, &fields)
+		for field, value := range fields {
+			flat["products."+name+"."+field] = string(value)
+		}
+	}
+	return flat
+}
+
+// unflattenConfig is the reverse of flattenConfig. The result is checked
+// the same way as an imported file.
+func unflattenConfig(flat map[string]string, base serverConfig) (serverConfig, error) {
+	p := portableConfig{
+		Users:           []string{},
+		NotifyChannels:  []string{},
+		ServiceChannels: []string{},
+		Watches:         map[string]string{},
+	}
+	products := map[string]map[string]json.RawMessage{}
+
+	for key, value := range flat {
+		group, rest, _ := strings.Cut(key, ".")
+		switch group {
+		case "active":
+			p.Active = value == "true"
+		case "interval_minutes":
+			p.IntervalMinutes, _ = strconv.Atoi(value)
+		case "format":
+			p.Format = manifest.Format(value)
+		case "locale":
+			p.Locale = manifest.Locale(value)
+		case "dashboard":
+			p.Dashboard = value == "true"
+		case "users":
+			p.Users = append(p.Users, rest)
+		case "notify_channels":
+			p.NotifyChannels = append(p.NotifyChannels, rest)
+		case "service_channels":
+			p.ServiceChannels = append(p.ServiceChannels, rest)
+		case "watches":
+			p.Watches[rest] = value
+		case "products":
+			// names may contain dots, fields do not
+			i := strings.LastIndex(rest, ".")
+			if i < 0 {
+				return serverConfig{}, errors.Join(ErrorInvalidConfig, fmt.Errorf("unknown setting %q", key))
+			}
+			name, field := rest[:i], rest[i+1:]
+			if products[name] == nil {
+				products[name] = map[string]json.RawMessage{}
+			}
+			products[name][field] = json.RawMessage(value)
+		default:
+			return serverConfig{}, errors.Join(ErrorInvalidConfig, fmt.Errorf("unknown setting %q", key))
+		}
+	}
+
+	// keep the order of channels, that are still there
+	sort.Strings(p.NotifyChannels)
+	sort.Strings(p.ServiceChannels)
+	p.NotifyChannels = keepOrder(base.NotifyChannels, p.NotifyChannels)
+	p.ServiceChannels = keepOrder(base.ServiceChannels, p.ServiceChannels)
+
+	p.Products = map[string]portableProduct{}
+	for name, fields := range products {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return serverConfig{}, err
+		}
+		var product portableProduct
+		err = json.Unmarshal(data, &product)
+		if err != nil {
+			return serverConfig{}, errors.Join(ErrorInvalidConfig, fmt.Errorf("product %q: %w", name, err))
+		}
+		p.Products[name] = product
+	}
+
+	return importConfig(p, base)
+}
+
+// keepOrder sorts items in the order they have in prev, new items last.
+func keepOrder(prev, items []string) []string {
+	result := []string{}
+	for _, item := range prev {
+		if slices.Contains(items, item) {
+			result = append(result, item)
+		}
+	}
+	for _, item := range items {
+		if !slices.Contains(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// configChanges lists the settings, that differ between two flattened
+// configs, sorted by name.
+func configChanges(before, after map[string]string) []permanence.AuditChange {
+	changes := []permanence.AuditChange{}
+	for key, value := range after {
+		if before[key] != value {
+			changes = append(changes, permanence.AuditChange{Key: key, Before: before[key], After: value})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, permanence.AuditChange{Key: key, Before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+func describeChange(change permanence.AuditChange) string {
+	switch {
+	case change.Before == "":
+		return fmt.Sprintf("+ %s: %s", change.Key, change.After)
+	case change.After == "":
+		return fmt.Sprintf("- %s: %s", change.Key, change.Before)
+	default:
+		return fmt.Sprintf("~ %s: %s → %s", change.Key, change.Before, change.After)
+	}
+}
+
+// diffConfigs describes how next differs from prev, one line per setting.
+func diffConfigs(prev, next serverConfig) []string {
+	lines := []string{}
+	for _, change := range configChanges(flattenConfig(prev), flattenConfig(next)) {
+		lines = append(lines, describeChange(change))
+	}
+	return lines
+}
+
+// ExportConfig writes the saved config as a portable JSON document.
+func ExportConfig(filename string) error {
+	_, err := loadServerConfig()
+	if err != nil {
+		return err
+	}
+
+	data, err := encodePortableConfig(config)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0600)
+}
+
+// loadStartupConfig replaces the saved config with a portable config file
+// and logs what changed.
+func loadStartupConfig(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := decodePortableConfig(bytes.NewReader(data), config)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("when reading %s", filename), err)
+	}
+
+	diff := diffConfigs(config, c)
+	config = c
+
+	return diff, saveServerConfig("startup", "-config "+filename)
+}
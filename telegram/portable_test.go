@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const partialConfig = `{"users": ["@pharmacist"], "notify_channels": ["-1001234"]}`
+
+func TestDecodeBootstrapConfigKeepsDefaults(t *testing.T) {
+	c, err := decodeBootstrapConfig(strings.NewReader(partialConfig), newServerConfig())
+	if err != nil {
+		t.Fatalf("decodeBootstrapConfig: %v", err)
+	}
+
+	defaults := newServerConfig()
+	if c.Interval != defaults.Interval || c.Format != defaults.Format || c.Locale != manifest.LocaleEnglish {
+		t.Errorf("got interval %v, format %q, locale %q", c.Interval, c.Format, c.Locale)
+	}
+	if _, ok := c.Whitelist["@pharmacist"]; !ok {
+		t.Errorf("got whitelist %v", c.Whitelist)
+	}
+}
+
+func TestDecodePortableConfigIsStrict(t *testing.T) {
+	_, err := decodePortableConfig(strings.NewReader(partialConfig), newServerConfig())
+	if !errors.Is(err, ErrorInvalidConfig) {
+		t.Fatalf("got %v, want %v", err, ErrorInvalidConfig)
+	}
+	for _, key := range []string{"interval_minutes", "format", "locale"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("missing %s is not reported: %v", key, err)
+		}
+	}
+
+	_, err = decodePortableConfig(strings.NewReader(`{"interval_minute": 30}`), newServerConfig())
+	if !errors.Is(err, ErrorInvalidConfig) {
+		t.Errorf("unknown key: got %v, want %v", err, ErrorInvalidConfig)
+	}
+
+	full := `{"interval_minutes": 15, "format": "html", "locale": "lv", "active": true}`
+	c, err := decodePortableConfig(strings.NewReader(full), newServerConfig())
+	if err != nil {
+		t.Fatalf("decodePortableConfig: %v", err)
+	}
+	if c.Interval != 15*time.Minute || c.Format != manifest.FormatHTML || c.Locale != manifest.LocaleLatvian || !c.Active {
+		t.Errorf("got interval %v, format %q, locale %q, active %v", c.Interval, c.Format, c.Locale, c.Active)
+	}
+}
@@ -1,6 +1,8 @@
 package telegram
 
 import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
 	"aphoteka_scraper/scraper"
 	"aphoteka_scraper/secrets"
 	"context"
@@ -9,6 +11,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/go-telegram/bot"
@@ -18,24 +22,70 @@ import (
 var ErrorCannotSetCommands = errors.New("settings telegram bot commands failed")
 var ErrorCannotSend = errors.New("cannot send message")
 var ErrorCannotSave = errors.New("cannot save server config")
+var ErrorCannotAudit = errors.New("server config saved, but cannot write the audit log")
 var ErrorCannotDumpManifest = errors.New("cannot create manifest dump")
 var ErrorCannotLoadManifest = errors.New("cannot open previous manifest file")
+var ErrorCannotPin = errors.New("cannot pin message")
+var ErrorCannotLoadHistory = errors.New("cannot load price history")
+var ErrorCannotLoadListings = errors.New("cannot load watched listings")
 
 var loopStopHandle chan<- struct{}
 var loopStopHandleValid = false
 var nextCheck time.Time
 var lastCheck time.Time
 
-func RunServer() error {
+// Products to check again soon, nil while no recheck is scheduled.
+var recheckNames map[string]struct{}
+var recheckMutex sync.Mutex
+
+// How soon a change waiting for confirmation is checked again.
+const confirmDelay = 2 * time.Minute
+
+// Options are set on the command line.
+type Options struct {
+	// Replaces the saved config on every start, see /import_config for the
+	// format.
+	ConfigFile string
+	// Seeds the config on the first start, in the same format.
+	BootstrapFile string
+	// Applies the bootstrap file again, even if there is a saved config.
+	ResetFromBootstrap bool
+}
+
+// RunServer runs the bot until it is interrupted.
+func RunServer(options Options) error {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	err := loadServerConfig()
+	found, err := loadServerConfig()
 	if err != nil {
 		return err
 	}
 
-	b, err := bot.New(secrets.Token)
+	var bootstrapped []string
+	if !found || options.ResetFromBootstrap {
+		bootstrapped, err = applyBootstrap(options.BootstrapFile, found)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied bootstrap config, %d changes", len(bootstrapped))
+		for _, line := range bootstrapped {
+			log.Print(line)
+		}
+	}
+
+	if options.ConfigFile != "" {
+		diff, err := loadStartupConfig(options.ConfigFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded config from %s, %d changes", options.ConfigFile, len(diff))
+		for _, line := range diff {
+			log.Print(line)
+		}
+	}
+
+	b, err := bot.New(secrets.Token, bot.WithDefaultHandler(handleDefault))
 	if err != nil {
 		return err
 	}
@@ -60,19 +110,51 @@ func RunServer() error {
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/add_product", bot.MatchTypePrefix, handleAddProduct)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/remove_product", bot.MatchTypePrefix, handleRemoveProduct)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/list_products", bot.MatchTypePrefix, handleListProducts)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/cancel", bot.MatchTypePrefix, handleCancel)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/search", bot.MatchTypePrefix, handleSearch)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/compare", bot.MatchTypePrefix, handleCompare)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/where", bot.MatchTypePrefix, handleWhere)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/add_watch", bot.MatchTypePrefix, handleAddWatch)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/remove_watch", bot.MatchTypePrefix, handleRemoveWatch)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/list_watches", bot.MatchTypePrefix, handleListWatches)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_confirmation", bot.MatchTypePrefix, handleSetConfirmation)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_target", bot.MatchTypePrefix, handleSetTarget)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_pack", bot.MatchTypePrefix, handleSetPack)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_stock_alert", bot.MatchTypePrefix, handleSetStockAlert)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_city_alert", bot.MatchTypePrefix, handleSetCityAlert)
 
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/force_update", bot.MatchTypePrefix, handleForceUpdate)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/check_now", bot.MatchTypePrefix, handleCheckNow)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/start_updates", bot.MatchTypePrefix, handleStartUpdates)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/stop_updates", bot.MatchTypePrefix, handleStopUpdates)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_update_interval", bot.MatchTypePrefix, handleSetUpdateInterval)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_format", bot.MatchTypePrefix, handleSetFormat)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/set_locale", bot.MatchTypePrefix, handleSetLocale)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/dashboard", bot.MatchTypePrefix, handleDashboard)
+
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/export_config", bot.MatchTypePrefix, handleExportConfig)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/import_config", bot.MatchTypePrefix, handleImportConfig)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/audit", bot.MatchTypePrefix, handleAudit)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/undo", bot.MatchTypePrefix, handleUndo)
+
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "p:", bot.MatchTypePrefix, handleProductCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "c:", bot.MatchTypePrefix, handleChannelCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "a:", bot.MatchTypePrefix, handleConversationCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "t:", bot.MatchTypePrefix, handleTrackCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "i:", bot.MatchTypePrefix, handleImportCallback)
 
 	if config.Active {
 		setupLoop(ctx, b)
 	}
 
+	go runIncidentSummaries(ctx, b)
+
 	log.Print("Server started")
 	notifyService(ctx, b, "Server started")
+	if options.ResetFromBootstrap {
+		notifyService(ctx, b, bootstrapReport(bootstrapped))
+	}
+	reportRecoveries(ctx, b)
 
 	b.Start(ctx)
 
@@ -97,12 +179,32 @@ func setupCommands(ctx context.Context, b *bot.Bot) error {
 			{Command: "/add_product", Description: "Adds a new product to be tracked"},
 			{Command: "/remove_product", Description: "Stops tracking some product"},
 			{Command: "/list_products", Description: "List currently tracked products"},
+			{Command: "/cancel", Description: "Cancel adding a product"},
+			{Command: "/search", Description: "Search the shops for products to track"},
+			{Command: "/compare", Description: "Compare price and stock of a product across shops"},
+			{Command: "/where", Description: "List pharmacies that have a product in stock"},
+			{Command: "/add_watch", Description: "Watch a category or search for new products and price changes"},
+			{Command: "/remove_watch", Description: "Stops watching a category or search"},
+			{Command: "/list_watches", Description: "List watched categories and searches"},
+			{Command: "/set_confirmation", Description: "Require changes of a product to be seen several times"},
+			{Command: "/set_target", Description: "Notify when a product costs at most the given price"},
+			{Command: "/set_pack", Description: "Set pack size of a product for unit prices"},
+			{Command: "/set_stock_alert", Description: "Notify when a product enters one of the given stock states"},
+			{Command: "/set_city_alert", Description: "Notify when a product appears in stock at a pharmacy in a city"},
 
 			{Command: "/force_update", Description: "Notify all channels, regardless of result"},
 			{Command: "/check_now", Description: "Check for result, as if it was scheduled"},
 			{Command: "/start_updates", Description: "Turns notifications and updates on"},
 			{Command: "/stop_updates", Description: "Turns notifications and updates off"},
 			{Command: "/set_update_interval", Description: "Sets update interval in minutes"},
+			{Command: "/set_format", Description: "Sets formatting of notifications: plain, markdown or html"},
+			{Command: "/set_locale", Description: "Sets how prices are written: en or lv"},
+			{Command: "/dashboard", Description: "Turns the pinned, live updated status message on or off"},
+
+			{Command: "/export_config", Description: "Sends the config as a JSON file"},
+			{Command: "/import_config", Description: "Replaces the config with an uploaded JSON file"},
+			{Command: "/audit", Description: "Lists recent config changes: who, when and what"},
+			{Command: "/undo", Description: "Reverts a config change listed by /audit"},
 		},
 	})
 
@@ -117,6 +219,26 @@ func setupCommands(ctx context.Context, b *bot.Bot) error {
 	return nil
 }
 
+// messageActor names the sender of a message for the audit log.
+func messageActor(update *models.Update) string {
+	if update.Message.From == nil {
+		return "unknown"
+	}
+	return userActor(*update.Message.From)
+}
+
+func callbackActor(update *models.Update) string {
+	return userActor(update.CallbackQuery.From)
+}
+
+// userActor names a user by the username, or by the id for users without one.
+func userActor(user models.User) string {
+	if user.Username == "" {
+		return fmt.Sprintf("user %d", user.ID)
+	}
+	return "@" + user.Username
+}
+
 func checkPermission(ctx context.Context, b *bot.Bot, update *models.Update) bool {
 	if _, ok := config.Whitelist["@"+update.Message.From.Username]; ok {
 		log.Printf("Command: %q", update.Message.Text)
@@ -162,7 +284,7 @@ func setupLoop(ctx context.Context, b *bot.Bot) {
 			select {
 			case now := <-ticker.C:
 				nextCheck = now.Add(d)
-				checkAndNotify(ctx, b, false)
+				checkAndNotify(ctx, b, checkOptions{watches: true})
 			case <-stop:
 				return
 			}
@@ -170,39 +292,175 @@ func setupLoop(ctx context.Context, b *bot.Bot) {
 	}()
 }
 
-func checkAndNotify(ctx context.Context, b *bot.Bot, forceUpdate bool) {
-	newManifest, needsUpdate, err := scraper.FetchAndCompare(config.Products)
+// checkOptions tell what a check covers.
+type checkOptions struct {
+	// Send the manifest, even when nothing changed.
+	force bool
+	// Watched listings are only read on the regular interval.
+	watches bool
+	// If not nil, only these products are checked.
+	only map[string]struct{}
+}
+
+// Checks run from the loop, from commands and from rechecks. They read and
+// write the config and the manifest, so only one runs at a time.
+var checkMutex sync.Mutex
+
+// checkAndNotify checks the products and sends out whatever changed.
+func checkAndNotify(ctx context.Context, b *bot.Bot, options checkOptions) {
+	checkMutex.Lock()
+	defer checkMutex.Unlock()
+
+	newManifest, needsUpdate, report, err := scraper.FetchAndCompare(config.Products, scraper.Settings{
+		Interval:      config.Interval,
+		Confirmations: config.Confirmations,
+		Paused:        config.Paused,
+		Packs:         config.Packs,
+		Only:          options.only,
+	})
 	lastCheck = time.Now()
 	error_slice := []error{}
 
-	if len(newManifest) == 0 {
-		notifyService(ctx, b, "No products are configured, no notifications will be sent.")
-		return
+	for _, status := range report.Degraded {
+		notifyService(ctx, b, fmt.Sprintf(
+			"%s is degraded: it keeps failing or rate-limiting us. Checks are slowed down, next attempt at %v.",
+			status.Domain, status.RetryAt.Format(time.DateTime),
+		))
+	}
+	for _, domain := range report.Recovered {
+		notifyService(ctx, b, fmt.Sprintf("%s has recovered, checks are back to the usual interval.", domain))
 	}
 
-	msg := newManifest.GenerateMessage()
+	// the shop moved a product, follow it
+	moved := false
+	for _, move := range report.Moved {
+		if config.Products[move.Product] != move.From {
+			continue
+		}
+		config.Products[move.Product] = move.To
+		moved = true
+		notifyService(ctx, b, fmt.Sprintf("Product %q has moved from %s to %s, its url is updated.",
+			move.Product, move.From, move.To))
+	}
+	if moved {
+		err := saveServerConfig("bot", "product moved")
+		handleSaveError(ctx, b, err)
+	}
+	reportRecoveries(ctx, b)
+
+	if len(report.Unconfirmed) > 0 {
+		log.Printf("Waiting for confirmation of changes: %q", report.Unconfirmed)
+		scheduleRecheck(ctx, b, report.Unconfirmed)
+	}
 
-	log.Print(msg)
 	if err != nil {
 		error_slice = append(error_slice, err)
 	}
+	fetched := report.Fetched
+	defer func() {
+		reportCheckErrors(ctx, b, errors.Join(error_slice...), fetched)
+	}()
+
+	if options.watches {
+		watched, err := checkWatches(ctx, b)
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+		fetched = append(fetched, watched...)
+	}
+
+	if len(newManifest) == 0 {
+		if len(config.Products) == 0 && len(config.Watches) == 0 {
+			notifyService(ctx, b, "No products are configured, no notifications will be sent.")
+		}
+		return
+	}
+
+	log.Print(newManifest.GenerateMessage())
 
-	if needsUpdate || forceUpdate {
+	if config.Dashboard && !options.force {
+		if needsUpdate {
+			err := sendChanges(ctx, b, newManifest, manifest.Changed(report.Previous, newManifest))
+			if err != nil {
+				error_slice = append(error_slice, err)
+			}
+		}
+	} else if needsUpdate || options.force {
 		for _, channel := range config.NotifyChannels {
-			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID: channel,
-				Text:   msg,
-				LinkPreviewOptions: &models.LinkPreviewOptions{
-					IsDisabled: bot.True(),
-				},
-			})
+			err := sendManifest(ctx, b, channel, newManifest)
 			if err != nil {
 				error_slice = append(error_slice, err)
 			}
 		}
 	}
 
-	handleError(ctx, b, errors.Join(error_slice...))
+	if needsUpdate {
+		err := sendAlerts(ctx, b, targetAlerts(report.Previous, newManifest))
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+
+		err = sendAlerts(ctx, b, stockAlerts(report.Previous, newManifest))
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+
+		// the manifest shows prices, but not whether a change is a sale or
+		// a new regular price
+		err = sendAlerts(ctx, b, priceAlerts(report.Previous, newManifest))
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+
+		err = sendAlerts(ctx, b, cheapestAlerts(report.Previous, newManifest))
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+	}
+
+	// stock in pharmacies is not a change of the manifest
+	err = sendAlerts(ctx, b, cityAlerts(report.Previous, newManifest))
+	if err != nil {
+		error_slice = append(error_slice, err)
+	}
+
+	if config.Dashboard {
+		err := updateDashboards(ctx, b, newManifest)
+		if err != nil {
+			error_slice = append(error_slice, err)
+		}
+	}
+}
+
+// scheduleRecheck checks the products with unconfirmed changes again soon,
+// so that they do not have to wait for the next regular check. Products are
+// added to a recheck, that is already scheduled.
+func scheduleRecheck(ctx context.Context, b *bot.Bot, names []string) {
+	recheckMutex.Lock()
+	defer recheckMutex.Unlock()
+
+	scheduled := recheckNames != nil
+	if !scheduled {
+		recheckNames = map[string]struct{}{}
+	}
+	for _, name := range names {
+		recheckNames[name] = unit
+	}
+	if scheduled {
+		return
+	}
+
+	time.AfterFunc(confirmDelay, func() {
+		recheckMutex.Lock()
+		names := recheckNames
+		recheckNames = nil
+		recheckMutex.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		checkAndNotify(ctx, b, checkOptions{only: names})
+	})
 }
 
 func handleError(ctx context.Context, b *bot.Bot, err error) {
@@ -210,13 +468,10 @@ func handleError(ctx context.Context, b *bot.Bot, err error) {
 		return
 	}
 
-	m := fmt.Sprint(err)
-	log.Print(m)
+	log.Print(err)
 
-	err = notifyService(ctx, b, m)
-
-	if err != nil {
-		log.Printf("Error sending service notifications: %v", err)
+	for _, m := range recordErrors(err, false) {
+		sendServiceError(ctx, b, m)
 	}
 }
 
@@ -231,9 +486,45 @@ func handleSaveError(ctx context.Context, b *bot.Bot, err error) {
 	if err == nil {
 		return
 	}
+	// the config itself was saved, only its audit entry is missing
+	if errors.Is(err, ErrorCannotAudit) {
+		handleError(ctx, b, err)
+		return
+	}
 	handleError(ctx, b, errors.Join(ErrorCannotSave, err))
 }
 
+func parseMode(format manifest.Format) models.ParseMode {
+	switch format {
+	case manifest.FormatHTML:
+		return models.ParseModeHTML
+	case manifest.FormatMarkdown:
+		return models.ParseModeMarkdown
+	default:
+		return ""
+	}
+}
+
+// sendManifest sends the manifest in the configured format, split across as
+// many messages as needed.
+func sendManifest(ctx context.Context, b *bot.Bot, chatID any, m manifest.Manifest) error {
+	for _, text := range m.Render(config.Format, config.Locale, manifest.MessageLimit) {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    chatID,
+			Text:      text,
+			ParseMode: parseMode(config.Format),
+			LinkPreviewOptions: &models.LinkPreviewOptions{
+				IsDisabled: bot.True(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func notifyService(ctx context.Context, b *bot.Bot, msg string) error {
 	msg = "[SERVICE]\n" + msg
 	error_slice := []error{}
@@ -249,3 +540,13 @@ func notifyService(ctx context.Context, b *bot.Bot, msg string) error {
 
 	return errors.Join(error_slice...)
 }
+
+// reportRecoveries tells service channels about files, that were damaged and
+// loaded from a backup.
+func reportRecoveries(ctx context.Context, b *bot.Bot) {
+	for _, r := range permanence.TakeRecoveries() {
+		log.Printf("Loaded %s instead of %s: %v", r.Backup, r.File, r.Err)
+		notifyService(ctx, b, fmt.Sprintf("%s could not be read (%v), loaded backup %s instead.",
+			filepath.Base(r.File), r.Err, filepath.Base(r.Backup)))
+	}
+}
@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// whereText lists the pharmacies, that have a product in stock, by city.
+func whereText(name string, a manifest.Availability) string {
+	if len(a.Stores) == 0 {
+		return fmt.Sprintf("The shop does not tell stock of %q in pharmacies.", name)
+	}
+
+	var s strings.Builder
+	city := ""
+	count := 0
+	for _, store := range a.Stores {
+		if !store.Stock.Buyable() {
+			continue
+		}
+		if count == 0 || store.City != city {
+			city = store.City
+			label := city
+			if label == "" {
+				label = "Other"
+			}
+			fmt.Fprintf(&s, "\n%s:\n", label)
+		}
+		count++
+
+		line := store.Name
+		if store.Address != "" {
+			line += ", " + store.Address
+		}
+		if store.Stock != manifest.StockInStock {
+			line += " (" + store.Stock.Wording() + ")"
+		}
+		fmt.Fprintf(&s, "%s %s\n", store.Stock.Icon(), line)
+	}
+
+	if count == 0 {
+		return fmt.Sprintf("No pharmacy has %q in stock.", name)
+	}
+	return fmt.Sprintf("%q is in stock at %d of %d pharmacies:\n%s",
+		name, count, len(a.Stores), strings.TrimSpace(s.String()))
+}
+
+// cityAlerts returns messages about products, that have just appeared in
+// stock at pharmacies of the city chosen for them.
+func cityAlerts(prev, next manifest.Manifest) []string {
+	alerts := []string{}
+	for name, city := range config.CityAlerts {
+		a, ok := next[name]
+		if !ok {
+			continue
+		}
+
+		// pharmacies of a chain share their name
+		type storeKey struct{ name, address string }
+		had := map[storeKey]bool{}
+		for _, store := range prev[name].StoresIn(city) {
+			if store.Stock.Buyable() {
+				had[storeKey{store.Name, store.Address}] = true
+			}
+		}
+
+		appeared := []string{}
+		for _, store := range a.StoresIn(city) {
+			if store.Stock.Buyable() && !had[storeKey{store.Name, store.Address}] {
+				label := store.Name
+				if store.Address != "" {
+					label += " (" + store.Address + ")"
+				}
+				appeared = append(appeared, label)
+			}
+		}
+		if len(appeared) == 0 {
+			continue
+		}
+
+		alerts = append(alerts, fmt.Sprintf("🏪 %s is now in stock in %s: %s\n%s",
+			name, city, strings.Join(appeared, ", "), a.Url))
+	}
+	sort.Strings(alerts)
+
+	return alerts
+}
+
+func handleWhere(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	name, ok := strings.CutPrefix(update.Message.Text, "/where ")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /where <name of product>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	lastManifest, err := permanence.LoadManifest()
+	if err != nil {
+		handleError(ctx, b, errors.Join(ErrorCannotLoadManifest, err))
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   whereText(name, lastManifest[name]),
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleSetCityAlert(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	// both the name and the city may contain spaces
+	s, ok := strings.CutPrefix(update.Message.Text, "/set_city_alert ")
+	fields := strings.Fields(s)
+	name, slice := splitProductArgs(fields, len(fields)-1)
+	if !ok || len(slice) == 0 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /set_city_alert <name of product> <city, or off>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Products[name]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Product %q is not found.", name),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	var text string
+	city := strings.Join(slice, " ")
+	if city == "off" {
+		delete(config.CityAlerts, name)
+		text = fmt.Sprintf("City alerts of %q are off.", name)
+	} else {
+		config.CityAlerts[name] = city
+		text = fmt.Sprintf("You will be notified when %q appears in stock at a pharmacy in %s.", name, city)
+	}
+
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	handleSendError(ctx, b, err)
+}
@@ -0,0 +1,265 @@
+package telegram
+
+import (
+	"aphoteka_scraper/manifest"
+	"aphoteka_scraper/permanence"
+	"aphoteka_scraper/scraper"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// At most this many products are named per kind of change of a watch.
+const maxWatchLines = 20
+
+func describeListed(p manifest.ListedProduct) string {
+	name := p.Name
+	if name == "" {
+		name = p.Availability.Url
+	}
+	return fmt.Sprintf("%s, %s", name, p.Availability.Price.Format(config.Locale))
+}
+
+func writeWatchSection(s *strings.Builder, title string, urls []string, line func(string) string) {
+	if len(urls) == 0 {
+		return
+	}
+	fmt.Fprintf(s, "\n%s:\n", title)
+	for i, url := range urls {
+		if i == maxWatchLines {
+			fmt.Fprintf(s, "... and %d more\n", len(urls)-i)
+			break
+		}
+		fmt.Fprintf(s, "%s\n", line(url))
+	}
+}
+
+// watchMessage describes what changed on a watched listing.
+func watchMessage(name string, prev, next manifest.Listing, diff manifest.ListingDiff) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "👀 %s\n", name)
+
+	writeWatchSection(&s, "New", diff.Added, func(url string) string {
+		return fmt.Sprintf("➕ %s\n%s", describeListed(next[url]), url)
+	})
+	writeWatchSection(&s, "Gone", diff.Removed, func(url string) string {
+		return fmt.Sprintf("➖ %s", describeListed(prev[url]))
+	})
+	writeWatchSection(&s, "Price changed", diff.Repriced, func(url string) string {
+		return fmt.Sprintf("💶 %s, was %s\n%s", describeListed(next[url]),
+			prev[url].Availability.Price.Format(config.Locale), url)
+	})
+
+	return strings.TrimSpace(s.String())
+}
+
+// WatchError is an error that happened while reading a watched listing.
+type WatchError struct {
+	Watch string
+	Url   string
+	Err   error
+}
+
+func (e *WatchError) Error() string {
+	return fmt.Sprintf("watch %q (%s): %v", e.Watch, e.Url, e.Err)
+}
+
+func (e *WatchError) Unwrap() error {
+	return e.Err
+}
+
+// watchIncident names a watch among products in incidents, see
+// reportCheckErrors.
+func watchIncident(name string) string {
+	return "watch " + name
+}
+
+// checkWatches fetches every watched listing and notifies about products,
+// that appeared, vanished or changed their price since the last check. The
+// first time a listing is seen, it is only remembered. Listings of shops,
+// that are backing off, are left for a later check. Returns the incident
+// names of the watches, that were read.
+func checkWatches(ctx context.Context, b *bot.Bot) ([]string, error) {
+	if len(config.Watches) == 0 {
+		return nil, nil
+	}
+
+	listings, err := permanence.LoadListings()
+	if err != nil {
+		return nil, errors.Join(ErrorCannotLoadListings, err)
+	}
+
+	error_slice := []error{}
+	alerts := []string{}
+	next := map[string]manifest.Listing{}
+	fetched := []string{}
+
+	for name, url := range config.Watches {
+		prev, seen := listings[name]
+
+		listing, err := scraper.FetchListing(url, prev, config.Interval)
+		if errors.Is(err, scraper.ErrorBackingOff) {
+			if seen {
+				next[name] = prev
+			}
+			continue
+		}
+		fetched = append(fetched, watchIncident(name))
+		if err != nil {
+			error_slice = append(error_slice, &WatchError{Watch: name, Url: url, Err: err})
+			if seen {
+				next[name] = prev
+			}
+			continue
+		}
+		next[name] = listing
+
+		if !seen {
+			continue
+		}
+		diff := manifest.DiffListings(prev, listing)
+		if !diff.Empty() {
+			alerts = append(alerts, watchMessage(name, prev, listing, diff))
+		}
+	}
+	sort.Strings(alerts)
+
+	err = permanence.SaveListings(next)
+	if err != nil {
+		error_slice = append(error_slice, errors.Join(ErrorCannotSave, err))
+	}
+
+	err = sendAlerts(ctx, b, alerts)
+	if err != nil {
+		error_slice = append(error_slice, err)
+	}
+
+	return fetched, errors.Join(error_slice...)
+}
+
+func handleAddWatch(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/add_watch")
+	slice := strings.Fields(s)
+	if !ok || len(slice) < 2 {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /add_watch <name> <url of a category or search>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	// the url never contains spaces, while the name may
+	name, raw := strings.Join(slice[:len(slice)-1], " "), slice[len(slice)-1]
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   "Reading the listing...",
+	})
+	handleSendError(ctx, b, err)
+
+	url, err := scraper.NormalizeUrl(raw)
+	var listing manifest.Listing
+	if err == nil {
+		listing, err = scraper.FetchListing(url, nil, config.Interval)
+	}
+	if err != nil {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Watch %q is not added: %v", name, err),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	config.Watches[name] = url
+	err = saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	listings, err := permanence.LoadListings()
+	if err == nil {
+		listings[name] = listing
+		err = permanence.SaveListings(listings)
+	}
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Watch %q added with url %q, %d products are listed now.", name, url, len(listing)),
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleRemoveWatch(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	s, ok := strings.CutPrefix(update.Message.Text, "/remove_watch ")
+	s = strings.TrimSpace(s)
+	if !ok || s == "" {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Syntax: /remove_watch <name>",
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+
+	if _, found := config.Watches[s]; !found {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Watch %q is not found.", s),
+		})
+		handleSendError(ctx, b, err)
+		return
+	}
+	delete(config.Watches, s)
+	err := saveServerConfig(messageActor(update), update.Message.Text)
+	handleSaveError(ctx, b, err)
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Watch %q is deleted.", s),
+	})
+	handleSendError(ctx, b, err)
+}
+
+func handleListWatches(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !checkPermission(ctx, b, update) {
+		return
+	}
+
+	names := make([]string, 0, len(config.Watches))
+	for name := range config.Watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text := "No categories or searches are watched."
+	if len(names) > 0 {
+		var s strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&s, "%s: %s\n", name, config.Watches[name])
+		}
+		text = s.String()
+	}
+
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	})
+	handleSendError(ctx, b, err)
+}